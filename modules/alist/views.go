@@ -11,7 +11,7 @@ import (
 )
 
 func list(c *gin.Context) {
-	c.JSON(http.StatusOK, server.Cfg.Alist)
+	c.JSON(http.StatusOK, server.Cfg.AlistServers())
 }
 
 func create(c *gin.Context) {
@@ -21,7 +21,7 @@ func create(c *gin.Context) {
 		return
 	}
 	item.Endpoint = strings.TrimSpace(item.Endpoint)
-	server.Cfg.Alist = append(server.Cfg.Alist, &item)
+	server.Cfg.AddAlist(&item)
 
 	// 立即持久化配置
 	if err := server.Cfg.Store(); err != nil {
@@ -35,52 +35,53 @@ func create(c *gin.Context) {
 func modify(c *gin.Context) {
 	alistName := c.Param("name")
 
-	for _, a := range server.Cfg.Alist {
-		if a.Name == alistName {
-			if err := c.ShouldBindJSON(&a); err != nil {
-				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-				return
-			}
-			a.Endpoint = strings.TrimSpace(a.Endpoint)
-
-			// 立即持久化配置
-			if err := server.Cfg.Store(); err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"code": -1, "msg": "Failed to save config: " + err.Error()})
-				return
-			}
-
-			c.JSON(http.StatusOK, gin.H{"code": 0, "msg": "success", "data": a})
-			return
-		}
+	old := server.Cfg.FindAlist(alistName)
+	if old == nil {
+		c.JSON(http.StatusNotFound, gin.H{"code": -1, "msg": "alist not found"})
+		return
+	}
+	// 在副本上修改后整体替换，正在进行的请求继续使用原来的配置
+	a := old.Clone()
+	if err := c.ShouldBindJSON(a); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	a.Endpoint = strings.TrimSpace(a.Endpoint)
+	if !server.Cfg.ReplaceAlist(old, a) {
+		c.JSON(http.StatusConflict, gin.H{"code": -1, "msg": "alist was modified, please retry"})
+		return
+	}
+
+	// 立即持久化配置
+	if err := server.Cfg.Store(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"code": -1, "msg": "Failed to save config: " + err.Error()})
+		return
 	}
-	c.JSON(http.StatusNotFound, gin.H{"code": -1, "msg": "alist not found"})
 
+	c.JSON(http.StatusOK, gin.H{"code": 0, "msg": "success", "data": a})
 }
+
 func del(c *gin.Context) {
 	alistName := c.Param("name")
 
-	for i, a := range server.Cfg.Alist {
-		if a.Name == alistName {
-			// 删除
-			server.Cfg.Alist = append(server.Cfg.Alist[:i], server.Cfg.Alist[i+1:]...)
-
-			// 立即持久化配置
-			if err := server.Cfg.Store(); err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"code": -1, "msg": "Failed to save config: " + err.Error()})
-				return
-			}
+	a := server.Cfg.DeleteAlist(alistName)
+	if a == nil {
+		c.JSON(http.StatusNotFound, gin.H{"code": -1, "msg": "alist not found"})
+		return
+	}
 
-			c.JSON(http.StatusOK, gin.H{"code": 0, "msg": "success", "data": a})
-			return
-		}
+	// 立即持久化配置
+	if err := server.Cfg.Store(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"code": -1, "msg": "Failed to save config: " + err.Error()})
+		return
 	}
-	c.JSON(http.StatusNotFound, gin.H{"code": -1, "msg": "alist not found"})
 
+	c.JSON(http.StatusOK, gin.H{"code": 0, "msg": "success", "data": a})
 }
 
 func listItem(c *gin.Context) {
 	idxStr := c.Param("idx")
-	// 从 url 参数中获取 path, page, pageSize, refresh
+	// 从 url 参数中获取 path, page, pageSize, refresh, password
 	root := c.Query("root")
 	pageStr := c.DefaultQuery("page", "1")
 	pageSizeStr := c.DefaultQuery("pageSize", "0")
@@ -90,12 +91,16 @@ func listItem(c *gin.Context) {
 	pageSize, _ := strconv.Atoi(pageSizeStr)
 	refresh, _ := strconv.ParseBool(refreshStr)
 
-	alist := server.Cfg.Alist[idx]
+	alistServer := server.Cfg.AlistServer(idx)
+	if alistServer == nil {
+		c.JSON(http.StatusNotFound, gin.H{"code": -1, "msg": "alist not found"})
+		return
+	}
 
-	data, err := alist.List(c, root, page, pageSize, refresh)
+	data, total, err := alistServer.ListPage(alist.WithPassword(c, c.Query("password")), root, page, pageSize, refresh)
 	if err != nil {
 		c.JSON(http.StatusOK, gin.H{"code": 1, "msg": err.Error(), "data": nil})
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{"code": 0, "msg": "success", "data": data})
+	c.JSON(http.StatusOK, gin.H{"code": 0, "msg": "success", "data": gin.H{"content": data, "total": total, "page": page, "pageSize": pageSize}})
 }
@@ -15,5 +15,12 @@ func Init() {
 		api.GET("/:id/list-item", listItem)
 		api.POST("/:id", run)
 		api.PUT("/:id", modify)
+		api.POST("/:id/cancel", cancel)
+		api.GET("/:id/progress", progress)
+		api.DELETE("/:id/index", resetIndex)
+		api.POST("/:id/plan", plan)
+		api.GET("/:id/plan", getPlan)
+		api.GET("/:id/runs", runs)
+		api.GET("/:id/runs/:runId", getRun)
 	}
 }
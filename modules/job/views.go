@@ -2,9 +2,12 @@ package job
 
 import (
 	"astrm/server"
+	"astrm/service/alist"
 	"astrm/service/job"
+	"encoding/json"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
@@ -20,6 +23,12 @@ func create(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	// Key 关联索引、清理清单和运行记录，总是为新任务生成，忽略客户端提交的值
+	item.Key = uuid.NewString()
+	if err := item.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"code": -1, "msg": err.Error()})
+		return
+	}
 
 	err := server.Cfg.RegisterJob(&item)
 	if err != nil {
@@ -33,7 +42,7 @@ func create(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusCreated, gin.H{"code": 0, "msg": "success", "data": item})
+	c.JSON(http.StatusCreated, gin.H{"code": 0, "msg": "success", "data": &item})
 }
 func del(c *gin.Context) {
 	jobId := c.Param("id")
@@ -71,7 +80,15 @@ func run(c *gin.Context) {
 	jobId := c.Param("id")
 
 	if idx, thisJob := server.Cfg.FindJob(&job.Job{Id: jobId}); idx != -1 {
-		go thisJob.Run()
+		state, err := thisJob.Trigger(job.TriggerManual)
+		if err != nil {
+			c.JSON(http.StatusConflict, gin.H{"code": -1, "msg": err.Error(), "data": thisJob})
+			return
+		}
+		if state != job.TriggerStarted {
+			c.JSON(http.StatusAccepted, gin.H{"code": 0, "msg": state, "data": thisJob})
+			return
+		}
 		c.JSON(http.StatusOK, gin.H{"code": 0, "msg": "success", "data": thisJob})
 		return
 	}
@@ -85,10 +102,35 @@ func modify(c *gin.Context) {
 	idx, thisJob := server.Cfg.FindJob(&job.Job{Id: jobId})
 	if idx != -1 {
 		rawSpec := thisJob.Spec
-		if err := c.ShouldBindJSON(&thisJob); err != nil {
+		body, err := c.GetRawData()
+		if err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
+		// 解码到当前配置的副本上校验，未提交的字段保留原值，不直接修改正在使用的任务
+		item := thisJob.Config()
+		if err = json.Unmarshal(body, item); err == nil {
+			server.SetJobDefaults(item)
+			err = item.Validate()
+		}
+		if err == nil {
+			// 源端类型或 alist 可能发生了变化
+			err = server.Cfg.SetHandler(item)
+		}
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"code": -1, "msg": err.Error()})
+			return
+		}
+		// 只复制配置字段，Key 和运行状态不允许修改，运行中拒绝修改
+		if err = thisJob.Update(item); err != nil {
+			c.JSON(http.StatusConflict, gin.H{"code": -1, "msg": err.Error()})
+			return
+		}
+		// Handler 需要读取注册的任务，而不是解码用的副本
+		if err = server.Cfg.SetHandler(thisJob); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"code": -1, "msg": err.Error()})
+			return
+		}
 		// 变化了要重新注册 job
 		if thisJob.Spec != rawSpec {
 			if err := server.Cfg.UnRegisterJob(thisJob); err != nil {
@@ -115,7 +157,7 @@ func modify(c *gin.Context) {
 
 func listItem(c *gin.Context) {
 	jobId := c.Param("id")
-	// 从 url 参数中获取 path, page, pageSize, refresh
+	// 从 url 参数中获取 path, page, pageSize, refresh, password
 	root := c.Query("root")
 	pageStr := c.DefaultQuery("page", "1")
 	pageSizeStr := c.DefaultQuery("pageSize", "0")
@@ -126,18 +168,208 @@ func listItem(c *gin.Context) {
 
 	idx, thisJob := server.Cfg.FindJob(&job.Job{Id: jobId})
 	if idx != -1 {
-		alistIdx := thisJob.Alist
-		alist := server.Cfg.Alist[alistIdx]
+		group, err := server.Cfg.AlistGroup(thisJob)
+		if err != nil {
+			c.JSON(http.StatusOK, gin.H{"code": 1, "msg": err.Error(), "data": nil})
+			return
+		}
 
-		data, err := alist.List(c, root, page, pageSize, refresh)
+		data, total, err := group.ListPage(alist.WithPassword(c, c.Query("password")), root, page, pageSize, refresh)
 		if err != nil {
 			c.JSON(http.StatusOK, gin.H{"code": 1, "msg": err.Error(), "data": nil})
 			return
 		}
-		c.JSON(http.StatusOK, gin.H{"code": 0, "msg": "success", "data": data})
+		c.JSON(http.StatusOK, gin.H{"code": 0, "msg": "success", "data": gin.H{"content": data, "total": total, "page": page, "pageSize": pageSize}})
 
 	} else {
 		c.JSON(http.StatusNotFound, gin.H{"code": -1, "msg": "Job not found"})
 
 	}
 }
+
+// resetIndex 清空任务的增量同步索引，下次运行时全量同步
+func resetIndex(c *gin.Context) {
+	jobId := c.Param("id")
+	idx, thisJob := server.Cfg.FindJob(&job.Job{Id: jobId})
+	if idx == -1 {
+		c.JSON(http.StatusNotFound, gin.H{"code": -1, "msg": "Job not found"})
+		return
+	}
+
+	if thisJob.Running() {
+		c.JSON(http.StatusConflict, gin.H{"code": -1, "msg": "Job is running"})
+		return
+	}
+
+	if err := job.ResetIndex(thisJob.Key); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"code": -1, "msg": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 0, "msg": "success", "data": thisJob})
+}
+
+// plan 在后台试运行任务，任务运行中时返回 409，结果通过 getPlan 轮询分页查询
+func plan(c *gin.Context) {
+	jobId := c.Param("id")
+	idx, thisJob := server.Cfg.FindJob(&job.Job{Id: jobId})
+	if idx == -1 {
+		c.JSON(http.StatusNotFound, gin.H{"code": -1, "msg": "Job not found"})
+		return
+	}
+
+	p, err := thisJob.Plan()
+	if err != nil {
+		c.JSON(http.StatusConflict, gin.H{"code": -1, "msg": err.Error()})
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{"code": 0, "msg": "success", "data": planPage(c, p)})
+}
+
+// getPlan 分页查询最后一次试运行的结果，done 为 false 时试运行仍在进行
+func getPlan(c *gin.Context) {
+	jobId := c.Param("id")
+	idx, thisJob := server.Cfg.FindJob(&job.Job{Id: jobId})
+	if idx == -1 {
+		c.JSON(http.StatusNotFound, gin.H{"code": -1, "msg": "Job not found"})
+		return
+	}
+	p := thisJob.LastPlan()
+	if p == nil {
+		c.JSON(http.StatusNotFound, gin.H{"code": -1, "msg": "Plan not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 0, "msg": "success", "data": planPage(c, p)})
+}
+
+func planPage(c *gin.Context, p *job.Plan) gin.H {
+	// 从 url 参数中获取 action, page, pageSize
+	action := c.Query("action")
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("pageSize", "100"))
+	summary, done, planErr := p.State()
+	items, total := p.Page(action, page, pageSize)
+	return gin.H{
+		"created":  p.Created,
+		"summary":  summary,
+		"error":    planErr,
+		"done":     done,
+		"total":    total,
+		"page":     page,
+		"pageSize": pageSize,
+		"items":    items,
+	}
+}
+
+// runs 查询任务的运行记录，最新的在前
+func runs(c *gin.Context) {
+	jobId := c.Param("id")
+	idx, thisJob := server.Cfg.FindJob(&job.Job{Id: jobId})
+	if idx == -1 {
+		c.JSON(http.StatusNotFound, gin.H{"code": -1, "msg": "Job not found"})
+		return
+	}
+
+	records, err := job.Runs(thisJob.Key)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"code": -1, "msg": err.Error()})
+		return
+	}
+	if records == nil {
+		records = []*job.Record{}
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 0, "msg": "success", "data": records})
+}
+
+func getRun(c *gin.Context) {
+	jobId := c.Param("id")
+	idx, thisJob := server.Cfg.FindJob(&job.Job{Id: jobId})
+	if idx == -1 {
+		c.JSON(http.StatusNotFound, gin.H{"code": -1, "msg": "Job not found"})
+		return
+	}
+
+	record, err := job.FindRun(thisJob.Key, c.Param("runId"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"code": -1, "msg": err.Error()})
+		return
+	}
+	if record == nil {
+		c.JSON(http.StatusNotFound, gin.H{"code": -1, "msg": "Run not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 0, "msg": "success", "data": record})
+}
+
+// cancel 取消正在运行的任务
+func cancel(c *gin.Context) {
+	jobId := c.Param("id")
+	idx, thisJob := server.Cfg.FindJob(&job.Job{Id: jobId})
+	if idx == -1 {
+		c.JSON(http.StatusNotFound, gin.H{"code": -1, "msg": "Job not found"})
+		return
+	}
+
+	if !thisJob.Cancel() {
+		c.JSON(http.StatusConflict, gin.H{"code": -1, "msg": "Job is not running"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"code": 0, "msg": "success", "data": thisJob})
+}
+
+// progress 使用 SSE 实时推送任务进度，运行结束时推送运行记录作为汇总
+func progress(c *gin.Context) {
+	jobId := c.Param("id")
+	idx, thisJob := server.Cfg.FindJob(&job.Job{Id: jobId})
+	if idx == -1 {
+		c.JSON(http.StatusNotFound, gin.H{"code": -1, "msg": "Job not found"})
+		return
+	}
+
+	// 设置 SSE 响应头
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+
+	var runId string
+	send := func() bool {
+		if p := thisJob.Progress(); p != nil && (runId == "" || p.RunId == runId) {
+			runId = p.RunId
+			c.SSEvent("progress", p)
+			c.Writer.Flush()
+			return true
+		}
+
+		// 运行已经结束（或已切换到排队的下一次运行），推送汇总
+		var record *job.Record
+		if runId != "" {
+			record, _ = job.FindRun(thisJob.Key, runId)
+		} else if records, _ := job.Runs(thisJob.Key); len(records) > 0 {
+			record = records[0]
+		}
+		c.SSEvent("summary", record)
+		c.Writer.Flush()
+		return false
+	}
+
+	if !send() {
+		return
+	}
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	// 监听客户端断开
+	clientGone := c.Request.Context().Done()
+
+	for {
+		select {
+		case <-clientGone:
+			return
+		case <-ticker.C:
+			if !send() {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package job
+
+import (
+	"astrm/server"
+	"astrm/service/alist"
+	"astrm/service/job"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/robfig/cron/v3"
+)
+
+// setCfg 使用只有两个 alist 的临时配置，测试结束后恢复
+func setCfg(t *testing.T) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	cfg := server.Cfg
+	server.Cfg = &server.Storage{
+		Alist:      []*alist.Server{{Name: "main"}, {Id: 1, Name: "backup"}},
+		Cron:       cron.New(cron.WithSeconds()),
+		ConfigPath: filepath.Join(t.TempDir(), "config.yaml"),
+	}
+	t.Cleanup(func() { server.Cfg = cfg })
+}
+
+func TestModifyPartial(t *testing.T) {
+	setCfg(t)
+
+	j := &job.Job{
+		Id:          "new", // 非空 Id 表示新建的任务，注册后加入任务列表
+		Name:        "old",
+		Alist:       1,
+		From:        "/media",
+		Dest:        "/strm",
+		Mode:        "raw_url",
+		Spec:        "0 0 * * * *",
+		Concurrency: 4,
+		Opts:        &job.Opts{Deep: 1, Include: []string{"*.mkv"}},
+	}
+	if err := server.Cfg.RegisterJob(j); err != nil {
+		t.Fatal(err)
+	}
+	include := j.Opts.Include
+
+	r := gin.New()
+	r.PUT("/api/job/:id", modify)
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPut, "/api/job/"+j.Id, strings.NewReader(`{"name":"new","opts":{"include":["*.mp4"]}}`))
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("PUT = %d %s", w.Code, w.Body)
+	}
+
+	if j.Name != "new" {
+		t.Errorf("Name = %q, want new", j.Name)
+	}
+	if j.Alist != 1 || j.From != "/media" || j.Dest != "/strm" || j.Mode != "raw_url" || j.Spec != "0 0 * * * *" || j.Concurrency != 4 {
+		t.Errorf("untouched fields changed: %+v", j)
+	}
+	if j.Opts.Deep != 1 || j.Opts.Filters != server.VideoRegex {
+		t.Errorf("Opts = %+v, want deep and default filters kept", j.Opts)
+	}
+	if len(j.Opts.Include) != 1 || j.Opts.Include[0] != "*.mp4" {
+		t.Errorf("Include = %v, want [*.mp4]", j.Opts.Include)
+	}
+	// 解码不能修改原配置的切片
+	if include[0] != "*.mkv" {
+		t.Errorf("old Include = %v, was modified in place", include)
+	}
+	if len(server.Cfg.Cron.Entries()) != 1 {
+		t.Errorf("cron entries = %d, want the job still scheduled", len(server.Cfg.Cron.Entries()))
+	}
+	if j.Handler == nil {
+		t.Error("Handler is nil")
+	}
+}
+
+func TestCreateLocal(t *testing.T) {
+	setCfg(t)
+	r := gin.New()
+	r.POST("/api/job", create)
+	tests := []struct {
+		body     string
+		wantCode int
+		wantMode string
+	}{
+		// 本地源端默认按 pathMap 映射，不需要 alist
+		{`{"name":"local","source":"local","alist":5,"from":"/mnt","dest":"/strm"}`, http.StatusCreated, "url"},
+		{`{"name":"local","source":"local","alist":1,"mode":"alist_url","from":"/mnt","dest":"/strm"}`, http.StatusCreated, "alist_url"},
+		// alist_url 需要存在的 alist
+		{`{"name":"local","source":"local","alist":5,"mode":"alist_url","from":"/mnt","dest":"/strm"}`, http.StatusInternalServerError, ""},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/job", strings.NewReader(tt.body)))
+		if w.Code != tt.wantCode {
+			t.Errorf("POST %s = %d %s, want %d", tt.body, w.Code, w.Body, tt.wantCode)
+			continue
+		}
+		if tt.wantMode == "" {
+			continue
+		}
+		var res struct {
+			Data job.Job `json:"data"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+			t.Fatal(err)
+		}
+		if res.Data.Mode != tt.wantMode {
+			t.Errorf("POST %s mode = %q, want %q", tt.body, res.Data.Mode, tt.wantMode)
+		}
+	}
+}
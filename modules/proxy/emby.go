@@ -2,10 +2,11 @@ package proxy
 
 import (
 	"astrm/server"
+	"astrm/service/alist"
 	"astrm/service/emby"
+	"astrm/service/notify"
 	"astrm/utils"
 	"bytes"
-	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -182,8 +183,8 @@ func (embyServerHandler *EmbyServerHandler) ModifyPlaybackInfo(rw *http.Response
 			}
 
 			if playbackInfoResponse.MediaSources[index].Size == nil {
-				alistServer := server.Cfg.Alist[server.Cfg.Emby.AlistStrm[idx].Alist]
-				fsGetData, err := alistServer.FsGet(context.TODO(), *mediasource.Path)
+				alistServer := server.Cfg.AlistServer(server.Cfg.Emby.AlistStrm[idx].Alist)
+				fsGetData, err := alistServer.FsGet(alist.Interactive(rw.Request.Context()), *mediasource.Path)
 				if err != nil {
 					logrus.Errorln("请求 FsGet 失败：", err)
 					continue
@@ -259,6 +260,12 @@ func (embyServerHandler *EmbyServerHandler) VideosHandler(ctx *gin.Context) {
 						logrus.Infoln("HTTPStrm 启用获取最终 URL，开始尝试获取最终 URL")
 						if finalURL, err := utils.GetFinalURL(redirectURL, ctx.Request.UserAgent()); err != nil {
 							logrus.Warningln("获取最终 URL 失败，使用原始 URL：", err)
+							notify.Emit(notify.Event{
+								Type:    notify.EventRedirectError,
+								Title:   "[astrm] HTTPStrm 获取最终 URL 失败",
+								Message: fmt.Sprintf("%s\n%v", *mediasource.Path, err),
+								Data:    map[string]any{"item": *item.Path, "path": *mediasource.Path, "error": err.Error()},
+							})
 						} else {
 							redirectURL = finalURL
 						}
@@ -279,10 +286,16 @@ func (embyServerHandler *EmbyServerHandler) VideosHandler(ctx *gin.Context) {
 				return
 
 			case AlistStrm: // 无需判断 *mediasource.Container 是否以Strm结尾，当 AlistStrm 存储的位置有对应的文件时，*mediasource.Container 会被设置为文件后缀
-				alistServer := server.Cfg.Alist[server.Cfg.Emby.AlistStrm[idx].Alist]
-				fsGetData, err := alistServer.FsGet(context.TODO(), *mediasource.Path)
+				alistServer := server.Cfg.AlistServer(server.Cfg.Emby.AlistStrm[idx].Alist)
+				fsGetData, err := alistServer.FsGet(alist.Interactive(ctx.Request.Context()), *mediasource.Path)
 				if err != nil {
 					logrus.Errorln("请求 FsGet 失败：", err)
+					notify.Emit(notify.Event{
+						Type:    notify.EventRedirectError,
+						Title:   "[astrm] AlistStrm 重定向失败",
+						Message: fmt.Sprintf("%s\n%v", *mediasource.Path, err),
+						Data:    map[string]any{"item": *item.Path, "path": *mediasource.Path, "alist": alistServer.Name, "error": err.Error()},
+					})
 					return
 				}
 				var redirectURL string
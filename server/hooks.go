@@ -0,0 +1,132 @@
+package server
+
+import (
+	"astrm/service/emby"
+	"astrm/service/job"
+	"astrm/service/notify"
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// maxNotifyFiles 新增文件通知的消息中最多列出的文件数
+const maxNotifyFiles = 50
+
+func setupHooks() {
+	job.AddHook(notifyEmby)
+	job.AddHook(notifyEvents)
+}
+
+// setupNotifiers 启用配置正确的通知器
+func setupNotifiers() {
+	var valid []*notify.Notifier
+	for _, n := range Cfg.Notifiers {
+		if err := n.Validate(); err != nil {
+			logrus.Errorf("[Notify] %v, disabled", err)
+			continue
+		}
+		valid = append(valid, n)
+	}
+	notify.Setup(valid)
+}
+
+// notifyEvents 将任务的运行结果发送给通知器
+func notifyEvents(s *job.Session, r *job.Record) {
+	j := s.Job
+	data := map[string]any{
+		"job":   j.Name,
+		"key":   j.Key,
+		"runId": r.Id,
+		"stats": r.Stats,
+	}
+	elapsed := r.End.Sub(r.Start).Round(time.Second)
+
+	switch r.Status {
+	case "success":
+		notify.Emit(notify.Event{
+			Type:  notify.EventJobSuccess,
+			Title: fmt.Sprintf("[astrm] 任务 %s 运行成功", j.Name),
+			Message: fmt.Sprintf("任务 %s 运行成功，耗时 %s\n列出 %d 个文件，写入 %d 个（新增 %d 个），跳过 %d 个，extra %d 个，清理 %d 个，错误 %d 次",
+				j.Name, elapsed, r.Stats.Listed, r.Stats.Written, r.Stats.Added, r.Stats.Skipped, r.Stats.Extras, r.Stats.Removed, r.Stats.Errors),
+			Data: data,
+		})
+	case "failed":
+		data["error"] = r.Error
+		notify.Emit(notify.Event{
+			Type:    notify.EventJobFailed,
+			Title:   fmt.Sprintf("[astrm] 任务 %s 运行失败", j.Name),
+			Message: fmt.Sprintf("任务 %s 运行失败，耗时 %s\n%s\n%s", j.Name, elapsed, r.Error, strings.Join(r.ErrorSamples, "\n")),
+			Data:    data,
+		})
+	}
+
+	added := s.Added()
+	if len(added) == 0 {
+		return
+	}
+	names := make([]string, 0, len(added))
+	for _, p := range added {
+		if rel, err := filepath.Rel(j.Dest, p); err == nil && !strings.HasPrefix(rel, "..") {
+			p = rel
+		}
+		names = append(names, p)
+	}
+	data = map[string]any{"job": j.Name, "key": j.Key, "runId": r.Id, "files": names}
+	lines := names
+	if len(lines) > maxNotifyFiles {
+		lines = append(lines[:maxNotifyFiles:maxNotifyFiles], fmt.Sprintf("... 共 %d 个", len(names)))
+	}
+	notify.Emit(notify.Event{
+		Type:    notify.EventJobNewFiles,
+		Title:   fmt.Sprintf("[astrm] 任务 %s 新增 %d 个文件", j.Name, len(names)),
+		Message: strings.Join(lines, "\n"),
+		Data:    data,
+	})
+}
+
+// notifyEmby 任务运行成功并且有文件变化后通知 Emby 扫描媒体库
+func notifyEmby(s *job.Session, r *job.Record) {
+	j := s.Job
+	mode := j.Opts.EmbyNotify
+	if mode == "" || r.Status != "success" || Cfg.Emby.Addr == "" {
+		return
+	}
+	dirs := s.Changed()
+	if len(dirs) == 0 {
+		return
+	}
+
+	server := emby.New(Cfg.Emby.Addr, Cfg.Emby.ApiKey)
+	var err error
+	switch mode {
+	case "refresh":
+		err = server.LibraryRefresh()
+	default:
+		paths := make([]string, 0, len(dirs))
+		for _, dir := range dirs {
+			paths = append(paths, embyPath(j.Dest, j.Opts.EmbyPath, dir))
+		}
+		err = server.LibraryMediaUpdated(paths)
+	}
+	if err != nil {
+		logrus.Errorf("[Emby] job name: %s, notify emby error: %v", j.Name, err)
+		return
+	}
+	logrus.Infof("[Emby] job name: %s, notified emby (%s), %d changed dirs", j.Name, mode, len(dirs))
+}
+
+// embyPath 将 dest 下的本地目录映射为 Emby 中的路径
+func embyPath(dest, target, dir string) string {
+	if target == "" {
+		return dir
+	}
+	rel, err := filepath.Rel(dest, dir)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return dir
+	}
+	return path.Join(target, filepath.ToSlash(rel))
+}
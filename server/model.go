@@ -2,10 +2,15 @@ package server
 
 import (
 	"astrm/service/alist"
+	"astrm/service/httpdir"
 	"astrm/service/job"
+	"astrm/service/local"
+	"astrm/service/notify"
+	"astrm/service/webdav"
 	"fmt"
 	"os"
 	"strconv"
+	"sync"
 
 	"github.com/google/uuid"
 	"github.com/robfig/cron/v3"
@@ -42,19 +47,28 @@ type AlistStrm struct {
 }
 
 type Storage struct {
-	Debug       bool            `yaml:"debug"`
-	Persistence string          `yaml:"persistence"` // 保留用于向后兼容，但不再使用
-	Alist       []*alist.Server `yaml:"alist"`
-	Jobs        []*job.Job      `yaml:"jobs"`
-	Listen      string          `yaml:"listen"`
-	Cron        *cron.Cron      `yaml:"-"`
-	Emby        Emby            `yaml:"emby"`
+	Debug       bool               `yaml:"debug"`
+	Persistence string             `yaml:"persistence"` // 保留用于向后兼容，但不再使用
+	Alist       []*alist.Server    `yaml:"alist"`
+	WebDav      []*webdav.Server   `yaml:"webdav"`
+	Http        []*httpdir.Server  `yaml:"http"`
+	Jobs        []*job.Job         `yaml:"jobs"`
+	Listen      string             `yaml:"listen"`
+	Cron        *cron.Cron         `yaml:"-"`
+	Emby        Emby               `yaml:"emby"`
+	Notifiers   []*notify.Notifier `yaml:"notifiers"`
 	Log         struct {
 		Level int    `yaml:"level"`
 		Path  string `yaml:"path"`
 	} `yaml:"log"`
+	History struct {
+		Keep int `yaml:"keep"` // 每个任务保留的运行记录条数，默认 50
+	} `yaml:"history"`
 	Entrance   string `yaml:"entrance"`
-	ConfigPath string `yaml:"-"` // 配置文件路径，不保存到 YAML
+	DataDir    string `yaml:"dataDir"` // 运行数据目录，默认为配置文件同级的 data 目录
+	ConfigPath string `yaml:"-"`       // 配置文件路径，不保存到 YAML
+
+	alistMu sync.RWMutex // 保护 Alist，修改 alist 配置时替换为新的副本
 }
 
 func (s *Storage) fromYaml(path string) (err error) {
@@ -84,20 +98,86 @@ func (s *Storage) store(path string) (err error) {
 
 // Store 保存配置到持久化文件
 func (s *Storage) Store() error {
+	s.alistMu.RLock()
+	defer s.alistMu.RUnlock()
 	return s.store(s.ConfigPath)
 }
 
+// AlistServers 返回当前 alist 配置的快照
+func (s *Storage) AlistServers() []*alist.Server {
+	s.alistMu.RLock()
+	defer s.alistMu.RUnlock()
+	return append([]*alist.Server(nil), s.Alist...)
+}
+
+// AlistServer 返回序号为 idx 的 alist，不存在时返回 nil
+func (s *Storage) AlistServer(idx int) *alist.Server {
+	s.alistMu.RLock()
+	defer s.alistMu.RUnlock()
+	if idx < 0 || idx >= len(s.Alist) {
+		return nil
+	}
+	return s.Alist[idx]
+}
+
+// FindAlist 按名称查找 alist，不存在时返回 nil
+func (s *Storage) FindAlist(name string) *alist.Server {
+	s.alistMu.RLock()
+	defer s.alistMu.RUnlock()
+	for _, a := range s.Alist {
+		if a.Name == name {
+			return a
+		}
+	}
+	return nil
+}
+
+// AddAlist 新增 alist
+func (s *Storage) AddAlist(a *alist.Server) {
+	s.alistMu.Lock()
+	defer s.alistMu.Unlock()
+	a.Id = len(s.Alist)
+	s.Alist = append(s.Alist, a)
+}
+
+// ReplaceAlist 使用修改后的副本替换 old，不直接修改正在被请求读取的配置，old 已被替换或删除时返回 false
+func (s *Storage) ReplaceAlist(old, a *alist.Server) bool {
+	s.alistMu.Lock()
+	defer s.alistMu.Unlock()
+	for i := range s.Alist {
+		if s.Alist[i] == old {
+			s.Alist[i] = a
+			old.Forget()
+			return true
+		}
+	}
+	return false
+}
+
+// DeleteAlist 按名称删除 alist，返回被删除的配置
+func (s *Storage) DeleteAlist(name string) *alist.Server {
+	s.alistMu.Lock()
+	defer s.alistMu.Unlock()
+	for i, a := range s.Alist {
+		if a.Name == name {
+			s.Alist = append(s.Alist[:i], s.Alist[i+1:]...)
+			a.Forget()
+			return a
+		}
+	}
+	return nil
+}
+
 func (s *Storage) RegisterJob(j *job.Job) (err error) {
 	var entryID cron.EntryID
 	isInit := j.Id == ""
-	// 重新注册
-	j.Handler = Cfg.Alist[j.Alist]
-	if j.Opts.Filters == "" {
-		j.Opts.Filters = VideoRegex
+	if j.Key == "" {
+		j.Key = uuid.NewString()
 	}
-
-	if j.Mode == "" {
-		j.Mode = "alist_url"
+	SetJobDefaults(j)
+	// 重新注册
+	if err = s.SetHandler(j); err != nil {
+		return
 	}
 
 	if j.Spec != "" {
@@ -116,6 +196,84 @@ func (s *Storage) RegisterJob(j *job.Job) (err error) {
 	return
 }
 
+// SetJobDefaults 填充任务未配置的默认值，注册和修改任务时使用
+func SetJobDefaults(j *job.Job) {
+	if j.Opts == nil {
+		j.Opts = &job.Opts{}
+	}
+	if j.Opts.Filters == "" {
+		j.Opts.Filters = VideoRegex
+	}
+
+	if j.Mode == "" {
+		// 本地源端默认按 pathMap 映射，不依赖 alist
+		if j.Source == "local" {
+			j.Mode = "url"
+		} else {
+			j.Mode = "alist_url"
+		}
+	}
+}
+
+// handlerFunc 将函数用作任务的 Handler
+type handlerFunc func(s *job.Session) error
+
+func (f handlerFunc) Handle(s *job.Session) error {
+	return f(s)
+}
+
+// SetHandler 根据任务的源端类型设置 Handler
+//
+// 使用 alist 的任务在每次运行时读取最新的 alist 配置，修改 alist 后下一次运行生效
+func (s *Storage) SetHandler(j *job.Job) error {
+	switch j.Source {
+	case "", "alist":
+		if _, err := s.AlistGroup(j); err != nil {
+			return err
+		}
+		j.Handler = handlerFunc(func(sess *job.Session) error {
+			group, err := s.AlistGroup(j)
+			if err != nil {
+				return err
+			}
+			return group.Handle(sess)
+		})
+	case "local":
+		if j.Mode == "alist_url" && s.AlistServer(j.Alist) == nil {
+			return fmt.Errorf("job %s: alist %d not found", j.Name, j.Alist)
+		}
+		j.Handler = handlerFunc(func(sess *job.Session) error {
+			return (&local.Handler{Alist: s.AlistServer(j.Alist)}).Handle(sess)
+		})
+	case "webdav":
+		if j.Server < 0 || j.Server >= len(s.WebDav) {
+			return fmt.Errorf("job %s: webdav %d not found", j.Name, j.Server)
+		}
+		j.Handler = s.WebDav[j.Server]
+	case "http":
+		if j.Server < 0 || j.Server >= len(s.Http) {
+			return fmt.Errorf("job %s: http %d not found", j.Name, j.Server)
+		}
+		j.Handler = s.Http[j.Server]
+	default:
+		return fmt.Errorf("job %s: unknown source %q", j.Name, j.Source)
+	}
+	return nil
+}
+
+// AlistGroup 返回任务使用的 alist 服务器，主服务器在前，其后为按顺序故障转移的备用服务器
+func (s *Storage) AlistGroup(j *job.Job) (*alist.Group, error) {
+	group := &alist.Group{}
+	for _, idx := range append([]int{j.Alist}, j.Failover...) {
+		a := s.AlistServer(idx)
+		if a == nil {
+			return nil, fmt.Errorf("job %s: alist %d not found", j.Name, idx)
+		}
+		group.Servers = append(group.Servers, a)
+	}
+	return group, nil
+}
+
 func (s *Storage) UnRegisterJob(j *job.Job) (err error) {
 
 	if idx, j2 := s.FindJob(j); idx != -1 {
@@ -2,11 +2,13 @@ package server
 
 import (
 	"astrm/middleware"
+	"astrm/service/job"
 	"fmt"
 	"io"
 	"log"
 	"net"
 	"os"
+	"path/filepath"
 	"strings"
 
 	"github.com/gin-gonic/gin"
@@ -26,14 +28,45 @@ func setupCfg() (err error) {
 		a.Id = i
 		a.Endpoint = strings.TrimSpace(a.Endpoint)
 	}
+	for i, w := range Cfg.WebDav {
+		w.Id = i
+		w.Endpoint = strings.TrimSpace(w.Endpoint)
+	}
+	for i, h := range Cfg.Http {
+		h.Id = i
+		h.Endpoint = strings.TrimSpace(h.Endpoint)
+	}
+
+	if Cfg.DataDir == "" {
+		Cfg.DataDir = filepath.Join(filepath.Dir(Cfg.ConfigPath), "data")
+	}
+	job.DataDir = Cfg.DataDir
+	if Cfg.History.Keep > 0 {
+		job.HistoryKeep = Cfg.History.Keep
+	}
+
+	setupNotifiers()
 
 	Cfg.Cron = cron.New(cron.WithSeconds())
+	setupHooks()
 
+	// 旧配置中的任务没有持久化标识，注册时生成后需要保存
+	var missingKey bool
 	for _, j := range Cfg.Jobs {
+		missingKey = missingKey || j.Key == ""
+		if err := j.Validate(); err != nil {
+			logrus.Errorf("job name: %s, invalid config: %v", j.Name, err)
+		}
 		if err = Cfg.RegisterJob(j); err != nil {
 			return
 		}
 	}
+	// 写回失败（如配置文件只读挂载）时继续使用内存中的 Key，下次保存配置时再写入
+	if missingKey {
+		if storeErr := Cfg.Store(); storeErr != nil {
+			logrus.Errorf("save generated job keys error: %v", storeErr)
+		}
+	}
 	return
 }
 
@@ -82,14 +115,14 @@ func Init(configPath string) {
 
 	setupLog()
 
-	if err = setupCfg(); err != nil {
-		panic("setup job failure, err：" + err.Error())
-	}
-
 	// 保存配置文件路径，用于后续持久化
 	// 不再使用定时任务，改为每次修改后立即保存
 	Cfg.ConfigPath = configPath
 
+	if err = setupCfg(); err != nil {
+		panic("setup job failure, err：" + err.Error())
+	}
+
 	setupHttpServer()
 
 }
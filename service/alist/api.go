@@ -2,8 +2,7 @@ package alist
 
 import (
 	"astrm/service/job"
-	"astrm/utils/concurrent"
-	"astrm/utils/iterator"
+	"astrm/utils"
 	"astrm/utils/pandora"
 	"context"
 	"encoding/json"
@@ -11,20 +10,34 @@ import (
 	"io"
 	"net/http"
 	"net/url"
-	"path/filepath"
-	"regexp"
 	"strings"
-	"sync"
+	"sync/atomic"
 	"time"
-
-	"github.com/sirupsen/logrus"
 )
 
 type Server struct {
-	Id       int    `yaml:"-" json:"id"`
-	Name     string `yaml:"name" json:"name,omitempty"`
-	Endpoint string `yaml:"endpoint" json:"endpoint,omitempty"`
-	Token    string `yaml:"token" json:"token,omitempty"`
+	Id           int    `yaml:"-" json:"id"`
+	Name         string `yaml:"name" json:"name,omitempty"`
+	Endpoint     string `yaml:"endpoint" json:"endpoint,omitempty"`
+	Token        string `yaml:"token" json:"token,omitempty"`
+	Username     string `yaml:"username" json:"username,omitempty"` // 配置了用户名密码时登录获取 token，忽略 Token
+	Password     string `yaml:"password" json:"password,omitempty"`
+	PasswordHash string `yaml:"passwordHash" json:"passwordHash,omitempty"` // sha256(密码 + "-https://github.com/alist-org/alist")，配置后使用 /api/auth/login/hash 登录
+	PageSize     int    `yaml:"pageSize" json:"pageSize,omitempty"`         // 遍历目录时每页的文件数，0 表示一次列出整个目录
+	Limit        *Limit `yaml:"limit" json:"limit,omitempty"`               // 请求限速，不配置表示不限制
+
+	Passwords []PathPassword `yaml:"passwords" json:"passwords,omitempty"` // 目录密码，按最长前缀匹配
+}
+
+// Clone 复制配置，修改配置时修改副本后整体替换，正在进行的请求继续读取原来的配置
+func (a *Server) Clone() *Server {
+	c := *a
+	if a.Limit != nil {
+		limit := *a.Limit
+		c.Limit = &limit
+	}
+	c.Passwords = append([]PathPassword(nil), a.Passwords...)
+	return &c
 }
 
 type Result struct {
@@ -53,8 +66,9 @@ type Content struct {
 	Type     int64       `json:"type"`
 	Hashinfo string      `json:"hashinfo"`
 	HashInfo interface{} `json:"hash_info"`
-	Action   int         `json:"-"`
 	Endpoint string      `json:"endpoint"`
+
+	server *Server // 列出该文件的服务器，Sign 只对该服务器有效
 }
 
 type FsGet struct {
@@ -85,137 +99,140 @@ func (r *Result) Marshal() ([]byte, error) {
 	return json.Marshal(r)
 }
 
-// rateLimiter 用于控制请求间隔
-type rateLimiter struct {
-	mu          sync.Mutex
-	lastRequest time.Time
-	interval    time.Duration
+func (a *Server) Handle(s *job.Session) error {
+	return (&Group{Servers: []*Server{a}}).Handle(s)
 }
 
-func (rl *rateLimiter) Wait() {
-	rl.mu.Lock()
-	defer rl.mu.Unlock()
+func (g *Group) Handle(s *job.Session) error {
+	// 每次运行都先尝试主服务器
+	g.active.Store(0)
+	s.Ctx = context.WithValue(s.Ctx, sessionKey, s)
+	return job.Walk(s, &source{Group: g, job: s.Job})
+}
 
-	now := time.Now()
-	elapsed := now.Sub(rl.lastRequest)
+// source 将 alist 作为 job.Walk 的源端，Entry.Data 为 *Content
+type source struct {
+	*Group
+	job         *job.Job
+	primaryDown atomic.Bool // 本次运行中主服务器已经无法获取签名
+}
 
-	if elapsed < rl.interval {
-		waitTime := rl.interval - elapsed
-		fmt.Printf("[rate limit] 等待 %v 后发送请求\n", waitTime)
-		time.Sleep(waitTime)
+// signed 返回带有主服务器签名的文件，strm 内容始终使用主服务器的地址，
+// 备用服务器列出的签名对主服务器无效，需要向主服务器重新获取
+func (src *source) signed(ctx context.Context, content *Content) (*Content, error) {
+	primary := src.primary()
+	if content.server == nil || content.server == primary || content.Sign == "" {
+		return content, nil
+	}
+	// 主服务器不可用时推迟写入，不使任务失败
+	if src.primaryDown.Load() {
+		return nil, fmt.Errorf("sign %s: alist %s %w: %w", content.Name, primary.Label(), ErrUnavailable, job.ErrDeferred)
 	}
+	get, err := primary.FsGet(ctx, content.Name)
+	if err != nil {
+		if failover(ctx, err) {
+			src.primaryDown.Store(true)
+			err = fmt.Errorf("sign %s: %w: %w", content.Name, err, job.ErrDeferred)
+		}
+		return nil, err
+	}
+	c := *content
+	c.Sign = get.Sign
+	return &c, nil
+}
 
-	rl.lastRequest = time.Now()
+// Pace 任务的请求间隔在发送请求时与服务器的限速一起控制，每个任务单独计算
+func (src *source) Pace(ctx context.Context, interval float64) context.Context {
+	return withInterval(ctx, interval)
 }
 
-func (a *Server) Handle(j *job.Job) (err error) {
-	var pool *concurrent.Pool
-	ctx := context.TODO()
+func (src *source) List(ctx context.Context, dir string) (entries []*job.Entry, err error) {
+	var contents []*Content
+	if contents, err = src.Group.List(ctx, dir, 1, 0, src.job.Opts.Refresh); err != nil {
+		return
+	}
+	for _, content := range contents {
+		entries = append(entries, &job.Entry{
+			Path:     content.Name,
+			IsDir:    content.IsDir,
+			Size:     content.Size,
+			Modified: content.ModifyTime(),
+			Hash:     content.Hashes(),
+			Data:     content,
+		})
+	}
+	return
+}
 
-	// 创建限流器
-	if j.Opts.Interval != 0 {
-		limiter := &rateLimiter{
-			interval: time.Duration(j.Opts.Interval) * time.Second,
-		}
-		ctx = context.WithValue(ctx, "rate-limiter", limiter)
-		ctx = context.WithValue(ctx, "interval", j.Opts.Interval)
-	}
-
-	process := func(content *Content, o *job.SaveOpt) {
-		switch content.Action {
-		case 1:
-			// 获取内容
-			var result *http.Response
-			result, err = a.Stream(
-				ctx,
-				content.DownloadUrl(),
-				"GET",
-				"",
-				map[string]any{"User-Agent": "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/133.0.0.0 Safari/537.36 Edg/133.0.0.0"},
-			)
-			if err != nil {
-				logrus.Errorln(err)
-				return
-			}
-			o.Body = result.Body
-			defer func(Body io.ReadCloser) {
-				_ = Body.Close()
-			}(result.Body)
-
-		default:
-			o.Name = strings.ReplaceAll(o.Name, filepath.Ext(o.Name), ".strm")
-			// alist -> strm
-			switch j.Mode {
-			case "raw_url":
-				// 获取 raw url
-				if get, err := a.FsGet(ctx, content.Name); err == nil {
-					o.Body = strings.NewReader(get.RawURL)
-				} else {
-					logrus.Errorln(err)
-					return
-				}
-
-			case "alist_path":
-				o.Body = strings.NewReader(content.Name)
-			default:
-				o.Body = strings.NewReader(content.DownloadUrl())
-			}
-		}
-		err = job.Save(*o)
+// Strm 按任务的 Mode 返回 strm 内容，raw_url 需要请求 alist 获取
+func (src *source) Strm(ctx context.Context, e *job.Entry) (string, error) {
+	content := e.Data.(*Content)
+	switch src.job.Mode {
+	case "raw_url":
+		get, err := src.FsGet(ctx, content.Name)
+		return get.RawURL, err
+	case "alist_path":
+		return content.Name, nil
+	default:
+		content, err := src.signed(ctx, content)
 		if err != nil {
-			logrus.Errorln(err)
-			return
+			return "", err
 		}
-
+		return content.DownloadUrl(), nil
 	}
+}
 
-	if j.Concurrency < 1 {
-		j.Concurrency = 1
+// StrmData 模板中的 RawURL 只有用到时才请求 alist 获取
+func (src *source) StrmData(ctx context.Context, e *job.Entry) (*job.StrmData, error) {
+	content, err := src.signed(ctx, e.Data.(*Content))
+	if err != nil {
+		return nil, err
 	}
-	pool = concurrent.NewPool(j.Concurrency)
-
-	for _, from := range strings.Split(j.From, "\n") {
-		from = strings.TrimSpace(from)
-		if from == "" {
-			continue
-		}
-		it := a.FsList(ctx, from, true, j.Opts)
-		for ct := range it.Iter() {
-			if ct.Error != nil {
-				err = ct.Error
-				logrus.Errorln(err)
-				continue
-			}
+	return job.NewStrmData(src.primary().Endpoint, content.Name, content.Sign, content.Size, content.ModifyTime(), content.DownloadUrl(),
+		func() (string, error) {
+			get, err := src.FsGet(ctx, content.Name)
+			return get.RawURL, err
+		}), nil
+}
 
-			content := ct.Content
-			o := &job.SaveOpt{
-				Opts:       j.Opts,
-				From:       from,
-				Dest:       j.Dest,
-				Name:       content.Name,
-				ModifyTime: content.ModifyTime(),
-			}
-			pool.Submit(process, content, o)
+// Open 下载 extra 文件
+func (src *source) Open(ctx context.Context, e *job.Entry) (io.ReadCloser, error) {
+	return src.download(ctx, e.Data.(*Content))
+}
 
+func (a *Server) Json(ctx context.Context, uri, method, data string, headers map[string]any) (result Result, err error) {
+	var auth string
+	for attempt := 0; ; attempt++ {
+		result, auth, err = a.json(ctx, uri, method, data, headers)
+		// alist 在 token 过期时返回状态码或 code 401，两种情况共用一次重新登录和重试
+		if result.Code != http.StatusUnauthorized || attempt > 0 || !a.usePassword() {
+			return
 		}
+		a.expire(auth)
 	}
-	if pool != nil {
-		pool.Shutdown()
-	}
-	return
 }
 
-func (a *Server) Json(ctx context.Context, uri, method, data string, headers map[string]any) (result Result, err error) {
+func (a *Server) json(ctx context.Context, uri, method, data string, headers map[string]any) (result Result, auth string, err error) {
 	var res *http.Response
-	res, err = a.Stream(ctx, uri, method, data, headers)
+	res, auth, err = a.send(ctx, uri, method, data, headers, false)
 	if err != nil {
-		err = fmt.Errorf("uri: %s, err: %s", uri, err.Error())
+		err = fmt.Errorf("uri: %s, err: %w", uri, err)
 		return
 	}
 	defer func(Body io.ReadCloser) {
 		_ = Body.Close()
 	}(res.Body)
 
+	if res.StatusCode == http.StatusUnauthorized {
+		// 由 Json 重新登录后重试
+		result.Code = http.StatusUnauthorized
+		err = fmt.Errorf("uri: %s, status code: %d", uri, res.StatusCode)
+		return
+	}
+	if res.StatusCode >= http.StatusInternalServerError {
+		err = fmt.Errorf("uri: %s, status code: %d, %w", uri, res.StatusCode, ErrUnavailable)
+		return
+	}
 	if res.StatusCode != 200 {
 		err = fmt.Errorf("uri: %s, status code: %d", uri, res.StatusCode)
 		return
@@ -239,6 +256,24 @@ func (a *Server) Json(ctx context.Context, uri, method, data string, headers map
 }
 
 func (a *Server) Stream(ctx context.Context, uri, method, data string, headers map[string]any) (res *http.Response, err error) {
+	res, _, err = a.stream(ctx, uri, method, data, headers, true)
+	return
+}
+
+// stream 发送请求，使用用户名密码登录时遇到 401 会重新登录并重试一次，auth 为请求使用的 Authorization，
+// download 为 true 时不限制读取响应体的时间
+func (a *Server) stream(ctx context.Context, uri, method, data string, headers map[string]any, download bool) (res *http.Response, auth string, err error) {
+	for attempt := 0; ; attempt++ {
+		res, auth, err = a.send(ctx, uri, method, data, headers, download)
+		if err != nil || res.StatusCode != http.StatusUnauthorized || attempt > 0 || !a.usePassword() {
+			return
+		}
+		_ = res.Body.Close()
+		a.expire(auth)
+	}
+}
+
+func (a *Server) send(ctx context.Context, uri, method, data string, headers map[string]any, download bool) (res *http.Response, auth string, err error) {
 
 	var u string
 	if !strings.HasPrefix(uri, a.Endpoint) {
@@ -259,12 +294,17 @@ func (a *Server) Stream(ctx context.Context, uri, method, data string, headers m
 			return nil
 		},
 	}
+	if download {
+		// 响应体的读取时间由 ctx 控制
+		client.Timeout = 0
+		client.Transport = utils.DownloadTransport
+	}
 
 	var req *http.Request
 	if data != "" {
-		req, err = http.NewRequest(method, u, strings.NewReader(data))
+		req, err = http.NewRequestWithContext(ctx, method, u, strings.NewReader(data))
 	} else {
-		req, err = http.NewRequest(method, u, nil)
+		req, err = http.NewRequestWithContext(ctx, method, u, nil)
 	}
 	if err != nil {
 		err = fmt.Errorf("uri: %s, err: %s", uri, err.Error())
@@ -274,64 +314,46 @@ func (a *Server) Stream(ctx context.Context, uri, method, data string, headers m
 	for key, value := range headers {
 		req.Header.Add(key, fmt.Sprintf("%v", value))
 	}
-	req.Header.Add("Authorization", a.Token)
+	if auth, err = a.authorization(ctx); err != nil {
+		return
+	}
+	req.Header.Add("Authorization", auth)
 
-	// 请求间隔控制
-	// 使用限流器确保请求之间有足够的间隔
-	if ctx.Value("interval") != nil {
-		limiter := ctx.Value("rate-limiter").(*rateLimiter)
-		limiter.Wait()
+	// 服务器的限速由所有任务和播放请求共用
+	if err = a.scheduler().wait(ctx, strings.TrimPrefix(uri, a.Endpoint)); err != nil {
+		return
 	}
 
 	res, err = client.Do(req)
 	return
 }
 
-func (a *Server) FsList(ctx context.Context, path string, recursion bool, opts *job.Opts) (res *iterator.Iterator[*Content]) {
-
-	filterRegex := regexp.MustCompile(opts.Filters)
-	var extraFunc func(p string) bool
-	if opts.Extra != "" {
-		extraRegex := regexp.MustCompile(opts.Extra)
-		extraFunc = func(p string) bool { return extraRegex.MatchString(filepath.Ext(p)) }
-	}
-	filterFunc := func(p string) bool { return filterRegex.MatchString(filepath.Ext(p)) }
-
-	return iterator.Make(func(c context.Context, ch chan<- iterator.Data[*Content]) {
-		pending := []string{path}
-		for len(pending) > 0 {
-			path := pending[0]
-			pending = pending[1:]
-			data, err := a.List(ctx, path, 1, 0, opts.Refresh)
-			if err != nil {
-				logrus.Errorf("list %s error: %s", path, err)
-				ch <- iterator.Data[*Content]{Error: err}
-				continue
-			}
-
-			for _, content := range data {
-				if recursion && content.IsDir {
-					pending = append(pending, content.Name)
-				} else if filterFunc != nil && filterFunc(content.Name) {
-					ch <- iterator.Data[*Content]{Content: content}
-				} else if extraFunc != nil && extraFunc(content.Name) {
-					content.Action = 1
-					ch <- iterator.Data[*Content]{Content: content}
-				}
-
-			}
+// List 列出目录，pageSize 为 0 时按服务器的 PageSize 分页列出整个目录
+func (a *Server) List(ctx context.Context, path string, page, pageSize int, refresh bool) (res []*Content, err error) {
+	if pageSize > 0 || a.PageSize <= 0 {
+		res, _, err = a.ListPage(ctx, path, page, pageSize, refresh)
+		return
+	}
+	// 逐页列出直到取得 Total 个文件，只在第一页刷新
+	for page = 1; ; page++ {
+		data, total, err := a.ListPage(ctx, path, page, a.PageSize, refresh && page == 1)
+		if err != nil {
+			return nil, err
 		}
-
-	})
-
+		res = append(res, data...)
+		if len(data) < a.PageSize || int64(len(res)) >= total {
+			return res, nil
+		}
+	}
 }
 
-func (a *Server) List(ctx context.Context, path string, page, pageSize int, refresh bool) (res []*Content, err error) {
+// ListPage 列出目录的一页，total 为目录下的文件总数
+func (a *Server) ListPage(ctx context.Context, path string, page, pageSize int, refresh bool) (res []*Content, total int64, err error) {
 
-	data := fmt.Sprintf(`{"path":"%s","password":"","page":%d,"per_page":%d,"refresh":%t}`, path, page, pageSize, refresh)
-	result, err := a.Json(ctx, "api/fs/list", "POST", data, map[string]any{"Content-Type": "application/json"})
+	body, _ := json.Marshal(listRequest{Path: path, Password: a.passwordFor(ctx, path), Page: page, PerPage: pageSize, Refresh: refresh})
+	result, err := a.Json(ctx, "api/fs/list", "POST", string(body), map[string]any{"Content-Type": "application/json"})
 	if err != nil {
-		err = fmt.Errorf("[FsList Error] path: %s, %v", path, err)
+		err = fmt.Errorf("[FsList Error] path: %s, %w", path, err)
 		return
 	}
 
@@ -340,12 +362,13 @@ func (a *Server) List(ctx context.Context, path string, page, pageSize int, refr
 		return
 	}
 
-	if total := fsList.Total; total == 0 {
+	if total = fsList.Total; total == 0 {
 		return
 	}
 
 	for _, content := range fsList.Content {
 		content.Endpoint = a.Endpoint
+		content.server = a
 		content.Name = strings.Join([]string{path, content.Name}, "/")
 		res = append(res, &content)
 
@@ -355,10 +378,10 @@ func (a *Server) List(ctx context.Context, path string, page, pageSize int, refr
 
 func (a *Server) FsGet(ctx context.Context, path string) (content FsGet, err error) {
 	var result Result
-	data := fmt.Sprintf(`{"path":"%s","password":""}`, path)
-	result, err = a.Json(ctx, "api/fs/get", "POST", data, map[string]any{"Content-Type": "application/json"})
+	body, _ := json.Marshal(getRequest{Path: path, Password: a.passwordFor(ctx, path)})
+	result, err = a.Json(ctx, "api/fs/get", "POST", string(body), map[string]any{"Content-Type": "application/json"})
 	if err != nil {
-		err = fmt.Errorf("[FsGet Error] path: %s, %v", path, err)
+		err = fmt.Errorf("[FsGet Error] path: %s, %w", path, err)
 		return
 	}
 
@@ -378,13 +401,29 @@ func (c Content) DownloadUrl() (r string) {
 	return
 }
 
+// Hashes 返回存储提供的文件哈希，如 {"md5": "..."}，没有时返回 nil
+func (c Content) Hashes() (hashes map[string]string) {
+	info, ok := c.HashInfo.(map[string]any)
+	if !ok && c.Hashinfo != "" && c.Hashinfo != "null" {
+		_ = json.Unmarshal([]byte(c.Hashinfo), &info)
+	}
+	for k, v := range info {
+		if str, ok := v.(string); ok && str != "" {
+			if hashes == nil {
+				hashes = map[string]string{}
+			}
+			hashes[strings.ToLower(k)] = str
+		}
+	}
+	return
+}
+
 func (c Content) ProxyDownloadUrl() (r string) {
 	return strings.Replace(c.DownloadUrl(), "/d/", "/p/", 1)
 }
 
 func (c Content) ModifyTime() (t time.Time) {
-	layout := "2006-01-02T15:04:05.999Z"
-	// 解析时间字符串
-	t, _ = time.Parse(layout, c.Modified)
+	// alist 返回 RFC3339 格式的时间，部分存储带有时区，如 2024-01-02T15:04:05.123+08:00
+	t, _ = time.Parse(time.RFC3339, c.Modified)
 	return
 }
@@ -0,0 +1,224 @@
+package alist
+
+import (
+	"astrm/service/job"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeAlist 模拟 alist 的 /api/fs/list 和 /d/ 下载，tree 为目录 -> 内容
+func fakeAlist(t *testing.T, tree map[string][]Content) (*httptest.Server, func() []string) {
+	t.Helper()
+	var (
+		mu     sync.Mutex
+		listed []string
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/api/fs/list":
+			var req listRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				rw.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			mu.Lock()
+			listed = append(listed, req.Path)
+			mu.Unlock()
+			content, ok := tree[req.Path]
+			if !ok {
+				_ = json.NewEncoder(rw).Encode(map[string]any{"code": 500, "message": "object not found"})
+				return
+			}
+			_ = json.NewEncoder(rw).Encode(map[string]any{"code": 200, "message": "success", "data": map[string]any{"content": content, "total": len(content)}})
+		case path.Dir(r.URL.Path) == "/d/media":
+			_, _ = rw.Write([]byte("subtitle"))
+		default:
+			rw.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), listed...)
+	}
+}
+
+// setDataDir 将任务的运行数据目录设置为测试的临时目录，测试结束后恢复
+func setDataDir(t *testing.T) {
+	t.Helper()
+	dataDir := job.DataDir
+	job.DataDir = t.TempDir()
+	t.Cleanup(func() { job.DataDir = dataDir })
+}
+
+func waitPlan(t *testing.T, p *job.Plan) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if _, done, _ := p.State(); done {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("plan is not done")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestGroupHandle(t *testing.T) {
+	modified := "2024-01-02T15:04:05.123+08:00"
+	srv, listed := fakeAlist(t, map[string][]Content{
+		"/media": {
+			{Name: "Show", IsDir: true, Modified: modified},
+			{Name: "Other", IsDir: true, Modified: modified},
+			{Name: "movie.mkv", Size: 5, Modified: modified, Sign: "s1"},
+			{Name: "movie.srt", Size: 8, Modified: modified},
+			{Name: "readme.txt", Size: 1, Modified: modified},
+		},
+		"/media/Show":  {{Name: "ep1.mkv", Size: 5, Modified: modified}},
+		"/media/Other": {{Name: "ep2.mkv", Size: 5, Modified: modified}},
+	})
+	dest := t.TempDir()
+	setDataDir(t)
+
+	g := &Group{Servers: []*Server{{Endpoint: srv.URL}}}
+	j := &job.Job{
+		Key:         "alist",
+		Name:        "alist",
+		From:        "/media",
+		Dest:        dest,
+		Concurrency: 2,
+		Handler:     g,
+		Opts:        &job.Opts{Filters: `\.mkv$`, Extra: `\.srt$`, Parallel: 2, Exclude: []string{"/media/Other"}},
+	}
+
+	// 试运行与其他源端一样经过 job.Walk 的过滤
+	p, err := j.Plan()
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitPlan(t, p)
+	items, total := p.Page("", 1, 100)
+	want := []job.PlanItem{
+		{Action: job.ActionCreate, Source: "/media/Show/ep1.mkv", Path: filepath.Join(dest, "Show", "ep1.strm")},
+		{Action: job.ActionExtra, Source: "/media/movie.srt", Path: filepath.Join(dest, "movie.srt")},
+		{Action: job.ActionCreate, Source: "/media/movie.mkv", Path: filepath.Join(dest, "movie.strm")},
+	}
+	if total != len(want) {
+		t.Fatalf("plan = %+v, want %+v", items, want)
+	}
+	for i := range want {
+		if items[i] != want[i] {
+			t.Errorf("plan[%d] = %+v, want %+v", i, items[i], want[i])
+		}
+	}
+	for _, dir := range listed() {
+		if dir == "/media/Other" {
+			t.Error("excluded directory was listed")
+		}
+	}
+
+	// 正式运行写入 strm 并下载 extra 文件
+	if _, err = j.Trigger(job.TriggerManual); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for j.Running() && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if body, err := os.ReadFile(filepath.Join(dest, "movie.strm")); err != nil || string(body) != srv.URL+"/d/media/movie.mkv?sign=s1" {
+		t.Errorf("movie.strm = %q, %v", body, err)
+	}
+	if body, err := os.ReadFile(filepath.Join(dest, "movie.srt")); err != nil || string(body) != "subtitle" {
+		t.Errorf("movie.srt = %q, %v", body, err)
+	}
+	info, err := os.Stat(filepath.Join(dest, "Show", "ep1.strm"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mod, _ := time.Parse(time.RFC3339, modified); !info.ModTime().Equal(mod) {
+		t.Errorf("ep1.strm modified = %s, want %s", info.ModTime(), mod)
+	}
+}
+
+func TestListPages(t *testing.T) {
+	tests := []struct {
+		name     string
+		files    int   // 目录中实际的文件数
+		total    int64 // alist 返回的 total
+		pageSize int
+		want     int
+		requests []listRequest
+	}{
+		{"several pages", 7, 7, 3, 7, []listRequest{{Page: 1, PerPage: 3, Refresh: true}, {Page: 2, PerPage: 3}, {Page: 3, PerPage: 3}}},
+		// 取得 total 个文件后不再请求下一页
+		{"exact pages", 6, 6, 3, 6, []listRequest{{Page: 1, PerPage: 3, Refresh: true}, {Page: 2, PerPage: 3}}},
+		// total 偏大时遇到不满一页或空页结束
+		{"short page", 5, 9, 3, 5, []listRequest{{Page: 1, PerPage: 3, Refresh: true}, {Page: 2, PerPage: 3}}},
+		{"empty page", 6, 9, 3, 6, []listRequest{{Page: 1, PerPage: 3, Refresh: true}, {Page: 2, PerPage: 3}, {Page: 3, PerPage: 3}}},
+		{"empty dir", 0, 0, 3, 0, []listRequest{{Page: 1, PerPage: 3, Refresh: true}}},
+		// 未配置 PageSize 时一次列出整个目录
+		{"no paging", 7, 7, 0, 7, []listRequest{{Page: 1, Refresh: true}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				mu       sync.Mutex
+				requests []listRequest
+			)
+			srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+				var req listRequest
+				if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+					rw.WriteHeader(http.StatusBadRequest)
+					return
+				}
+				mu.Lock()
+				requests = append(requests, req)
+				mu.Unlock()
+				start, end := 0, tt.files
+				if req.PerPage > 0 {
+					start, end = min((req.Page-1)*req.PerPage, tt.files), min(req.Page*req.PerPage, tt.files)
+				}
+				content := []Content{}
+				for i := start; i < end; i++ {
+					content = append(content, Content{Name: fmt.Sprintf("%02d.mkv", i)})
+				}
+				_ = json.NewEncoder(rw).Encode(map[string]any{"code": 200, "message": "success", "data": map[string]any{"content": content, "total": tt.total}})
+			}))
+			defer srv.Close()
+
+			a := &Server{Endpoint: srv.URL, PageSize: tt.pageSize}
+			res, err := a.List(context.Background(), "/media", 1, 0, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(res) != tt.want {
+				t.Fatalf("List() returned %d files, want %d", len(res), tt.want)
+			}
+			for i, c := range res {
+				if want := fmt.Sprintf("/media/%02d.mkv", i); c.Name != want {
+					t.Errorf("res[%d] = %s, want %s", i, c.Name, want)
+				}
+			}
+			if len(requests) != len(tt.requests) {
+				t.Fatalf("requests = %+v, want %+v", requests, tt.requests)
+			}
+			for i, want := range tt.requests {
+				want.Path = "/media"
+				if requests[i] != want {
+					t.Errorf("request %d = %+v, want %+v", i, requests[i], want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,150 @@
+package alist
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// login 使用用户名密码登录得到的 token，配置变化后重新登录
+type login struct {
+	mu    sync.Mutex
+	key   string // 登录时的 endpoint、用户名和密码
+	token string
+	call  *loginCall // 正在进行的登录，并发的请求共用同一次登录
+}
+
+// loginCall 一次正在进行的登录，done 关闭后 token 和 err 可读
+type loginCall struct {
+	key   string
+	done  chan struct{}
+	token string
+	err   error
+}
+
+// logins *Server -> *login，与 schedulers 一样不放在 Server 中，由 Forget 清除
+var logins sync.Map
+
+func (a *Server) login() *login {
+	v, _ := logins.LoadOrStore(a, &login{})
+	return v.(*login)
+}
+
+// usePassword 是否使用用户名密码登录，否则使用配置的 Token
+func (a *Server) usePassword() bool {
+	return a.Username != "" && (a.Password != "" || a.PasswordHash != "")
+}
+
+// authorization 返回请求使用的 Authorization，需要时先登录
+//
+// 登录在后台进行且不持有锁，登录缓慢时等待的请求可以被各自的 ctx 取消，已有 token 的请求不受影响
+func (a *Server) authorization(ctx context.Context) (string, error) {
+	if !a.usePassword() {
+		return a.Token, nil
+	}
+	l := a.login()
+	key := a.Endpoint + "\x00" + a.Username + "\x00" + a.Password + "\x00" + a.PasswordHash
+	l.mu.Lock()
+	if l.key == key && l.token != "" {
+		token := l.token
+		l.mu.Unlock()
+		return token, nil
+	}
+	c := l.call
+	if c == nil || c.key != key {
+		c = &loginCall{key: key, done: make(chan struct{})}
+		l.call = c
+		// 发起登录的请求被取消时不影响其他等待的请求
+		go a.runLogin(context.WithoutCancel(ctx), l, c)
+	}
+	l.mu.Unlock()
+
+	select {
+	case <-c.done:
+		return c.token, c.err
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+// runLogin 执行一次登录，成功后保存 token，失败时下一个请求重新登录
+func (a *Server) runLogin(ctx context.Context, l *login, c *loginCall) {
+	token, err := a.fetchToken(ctx)
+	l.mu.Lock()
+	// 配置变化后已经开始了新的登录，不保存旧配置的 token
+	if l.call == c {
+		l.call = nil
+		if err == nil {
+			l.key, l.token = c.key, token
+		}
+	}
+	c.token, c.err = token, err
+	l.mu.Unlock()
+	close(c.done)
+}
+
+// expire 使 token 失效，下次请求时重新登录，token 已经被其他请求刷新时不处理
+func (a *Server) expire(token string) {
+	if !a.usePassword() {
+		return
+	}
+	l := a.login()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.token == token {
+		l.token = ""
+	}
+}
+
+// fetchToken 调用 /api/auth/login，配置了 PasswordHash 时调用 /api/auth/login/hash
+//
+// 错误信息中不包含用户名、密码和 token
+func (a *Server) fetchToken(ctx context.Context) (string, error) {
+	uri, password := "api/auth/login", a.Password
+	if a.PasswordHash != "" {
+		uri, password = "api/auth/login/hash", a.PasswordHash
+	}
+	u, err := url.JoinPath(a.Endpoint, uri)
+	if err != nil {
+		return "", fmt.Errorf("login alist %s: %w", a.Label(), err)
+	}
+	body, _ := json.Marshal(map[string]string{"username": a.Username, "password": password})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(body))
+	if err != nil {
+		return "", fmt.Errorf("login alist %s: %w", a.Label(), err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	res, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("login alist %s: %w", a.Label(), err)
+	}
+	defer func(Body io.ReadCloser) {
+		_ = Body.Close()
+	}(res.Body)
+	if res.StatusCode >= http.StatusInternalServerError {
+		return "", fmt.Errorf("login alist %s: status code: %d, %w", a.Label(), res.StatusCode, ErrUnavailable)
+	}
+
+	var result struct {
+		Code    int64  `json:"code"`
+		Message string `json:"message"`
+		Data    struct {
+			Token string `json:"token"`
+		} `json:"data"`
+	}
+	if err = json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("login alist %s: status code: %d, invalid response", a.Label(), res.StatusCode)
+	}
+	if result.Code != 200 || result.Data.Token == "" {
+		return "", fmt.Errorf("login alist %s: %s", a.Label(), result.Message)
+	}
+	return result.Data.Token, nil
+}
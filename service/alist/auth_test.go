@@ -0,0 +1,185 @@
+package alist
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeAuth 模拟需要登录的 alist 的 /api/auth/login 和 /api/fs/get
+type fakeAuth struct {
+	mu       sync.Mutex
+	token    string   // 当前有效的 token，为空时拒绝所有请求
+	logins   int      // 登录次数
+	requests int      // /api/fs/get 的请求次数
+	reject   []string // 依次使用的拒绝方式：status 返回 401 状态码，code 返回 code 401，为空时使用 status
+	failAuth bool     // 登录失败
+	expired  bool     // 登录返回的 token 立即失效
+}
+
+func (f *fakeAuth) counts() (logins, requests int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.logins, f.requests
+}
+
+func (f *fakeAuth) server(t *testing.T) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		switch r.URL.Path {
+		case "/api/auth/login":
+			f.logins++
+			if f.failAuth {
+				_ = json.NewEncoder(rw).Encode(map[string]any{"code": 400, "message": "password is incorrect"})
+				return
+			}
+			token := fmt.Sprintf("token-%d", f.logins)
+			if !f.expired {
+				f.token = token
+			}
+			_ = json.NewEncoder(rw).Encode(map[string]any{"code": 200, "message": "success", "data": map[string]any{"token": token}})
+		case "/api/fs/get":
+			f.requests++
+			if f.token == "" || r.Header.Get("Authorization") != f.token {
+				reject := "status"
+				if len(f.reject) > 0 {
+					reject, f.reject = f.reject[0], f.reject[1:]
+				}
+				if reject == "status" {
+					rw.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+				_ = json.NewEncoder(rw).Encode(map[string]any{"code": 401, "message": "token is expired"})
+				return
+			}
+			_ = json.NewEncoder(rw).Encode(map[string]any{"code": 200, "message": "success", "data": map[string]any{"name": "a.mkv"}})
+		default:
+			rw.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestJsonRelogin(t *testing.T) {
+	tests := []struct {
+		name         string
+		reject       []string
+		failAuth     bool
+		expired      bool
+		wantErr      bool
+		wantLogins   int
+		wantRequests int
+	}{
+		{name: "status 401", reject: []string{"status"}, wantLogins: 1, wantRequests: 2},
+		{name: "code 401", reject: []string{"code"}, wantLogins: 1, wantRequests: 2},
+		// 重新登录后仍然被拒绝时不再重试，状态码和 code 不会叠加重试
+		{name: "status then code", reject: []string{"status", "code"}, expired: true, wantErr: true, wantLogins: 1, wantRequests: 2},
+		{name: "code then status", reject: []string{"code", "status"}, expired: true, wantErr: true, wantLogins: 1, wantRequests: 2},
+		// 重新登录失败时不重试请求
+		{name: "login fails", reject: []string{"status"}, failAuth: true, wantErr: true, wantLogins: 1, wantRequests: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &fakeAuth{}
+			srv := f.server(t)
+			a := &Server{Endpoint: srv.URL, Username: "admin", Password: "secret"}
+			t.Cleanup(a.Forget)
+
+			// 第一次请求登录并使用 token
+			if _, err := a.Json(context.Background(), "api/fs/get", http.MethodPost, `{"path":"/a.mkv"}`, nil); err != nil {
+				t.Fatal(err)
+			}
+			baseLogins, baseRequests := f.counts()
+
+			// token 过期
+			f.mu.Lock()
+			f.token = ""
+			f.reject, f.failAuth, f.expired = tt.reject, tt.failAuth, tt.expired
+			f.mu.Unlock()
+
+			_, err := a.Json(context.Background(), "api/fs/get", http.MethodPost, `{"path":"/a.mkv"}`, nil)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Json() error = %v, want error %v", err, tt.wantErr)
+			}
+			logins, requests := f.counts()
+			if logins-baseLogins != tt.wantLogins || requests-baseRequests != tt.wantRequests {
+				t.Errorf("re-logins = %d, requests = %d, want %d, %d", logins-baseLogins, requests-baseRequests, tt.wantLogins, tt.wantRequests)
+			}
+		})
+	}
+}
+
+func TestSlowLogin(t *testing.T) {
+	release := make(chan struct{})
+	var (
+		mu     sync.Mutex
+		logins int
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		logins++
+		mu.Unlock()
+		<-release
+		_ = json.NewEncoder(rw).Encode(map[string]any{"code": 200, "message": "success", "data": map[string]any{"token": "token"}})
+	}))
+	t.Cleanup(srv.Close)
+	t.Cleanup(func() {
+		select {
+		case <-release:
+		default:
+			close(release)
+		}
+	})
+	a := &Server{Endpoint: srv.URL, Username: "admin", Password: "secret"}
+	t.Cleanup(a.Forget)
+
+	// 多个请求等待同一次登录
+	results := make(chan string, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			token, _ := a.authorization(context.Background())
+			results <- token
+		}()
+	}
+
+	// 登录缓慢时，等待中的请求可以被自己的 ctx 取消，使 token 失效也不会阻塞
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := a.authorization(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("authorization() during a slow login = %v, want context.DeadlineExceeded", err)
+	}
+	expired := make(chan struct{})
+	go func() {
+		a.expire("token")
+		close(expired)
+	}()
+	select {
+	case <-expired:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expire() blocked on a slow login")
+	}
+
+	close(release)
+	for i := 0; i < 2; i++ {
+		if token := <-results; token != "token" {
+			t.Errorf("authorization() = %q, want token", token)
+		}
+	}
+	if token, err := a.authorization(context.Background()); err != nil || token != "token" {
+		t.Errorf("authorization() after login = %q, %v", token, err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if logins != 1 {
+		t.Errorf("logins = %d, want 1", logins)
+	}
+}
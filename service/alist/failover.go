@@ -0,0 +1,136 @@
+package alist
+
+import (
+	"astrm/service/job"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync/atomic"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ErrUnavailable alist 返回了 5xx 状态码
+var ErrUnavailable = errors.New("server unavailable")
+
+// Group 按顺序故障转移的一组 alist 服务器，第一个为主服务器
+//
+// 连接失败或返回 5xx 时切换到下一个服务器，strm 内容始终使用主服务器的地址生成
+type Group struct {
+	Servers []*Server
+	active  atomic.Int32 // 最近一次请求成功的服务器
+}
+
+func (g *Group) primary() *Server {
+	return g.Servers[0]
+}
+
+// failover 是否应该切换到下一个服务器，任务被取消时不切换
+func failover(ctx context.Context, err error) bool {
+	if err == nil || ctx.Err() != nil {
+		return false
+	}
+	var urlErr *url.Error
+	return errors.As(err, &urlErr) || errors.Is(err, ErrUnavailable)
+}
+
+// do 从最近成功的服务器开始依次尝试，直到请求成功或出现不需要切换的错误
+func (g *Group) do(ctx context.Context, f func(a *Server) error) (err error) {
+	start := int(g.active.Load())
+	for i := range g.Servers {
+		idx := (start + i) % len(g.Servers)
+		a := g.Servers[idx]
+		if err = f(a); !failover(ctx, err) {
+			g.active.Store(int32(idx))
+			if s, ok := ctx.Value(sessionKey).(*job.Session); ok && ctx.Err() == nil {
+				s.Backend(a.Label())
+			}
+			return
+		}
+		if i < len(g.Servers)-1 {
+			logrus.Warningf("[Failover] alist %s error: %v, try next", a.Label(), err)
+		}
+	}
+	return
+}
+
+// List 列出目录，文件的 Endpoint 为主服务器的地址，Sign 仍为实际列出的服务器的签名
+func (g *Group) List(ctx context.Context, path string, page, pageSize int, refresh bool) (res []*Content, err error) {
+	err = g.do(ctx, func(a *Server) (err error) {
+		res, err = a.List(ctx, path, page, pageSize, refresh)
+		return
+	})
+	for _, content := range res {
+		content.Endpoint = g.primary().Endpoint
+	}
+	return
+}
+
+// ListPage 列出目录的一页，文件的 Endpoint 为主服务器的地址，Sign 仍为实际列出的服务器的签名
+func (g *Group) ListPage(ctx context.Context, path string, page, pageSize int, refresh bool) (res []*Content, total int64, err error) {
+	err = g.do(ctx, func(a *Server) (err error) {
+		res, total, err = a.ListPage(ctx, path, page, pageSize, refresh)
+		return
+	})
+	for _, content := range res {
+		content.Endpoint = g.primary().Endpoint
+	}
+	return
+}
+
+func (g *Group) FsGet(ctx context.Context, path string) (content FsGet, err error) {
+	err = g.do(ctx, func(a *Server) (err error) {
+		content, err = a.FsGet(ctx, path)
+		return
+	})
+	return
+}
+
+// download 下载文件内容，切换服务器时使用该服务器的下载地址
+func (g *Group) download(ctx context.Context, content *Content) (body io.ReadCloser, err error) {
+	err = g.do(ctx, func(a *Server) error {
+		c := *content
+		c.Endpoint = a.Endpoint
+		// 签名只对列出文件的服务器有效，加密目录下的文件需要签名才能下载
+		resign := c.Sign != "" && c.server != nil && c.server != a
+		if resign || c.Sign == "" && a.passwordFor(ctx, c.Name) != "" {
+			get, err := a.FsGet(ctx, c.Name)
+			if err != nil {
+				return err
+			}
+			c.Sign = get.Sign
+		}
+		result, err := a.Stream(
+			ctx,
+			c.DownloadUrl(),
+			"GET",
+			"",
+			map[string]any{"User-Agent": "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/133.0.0.0 Safari/537.36 Edg/133.0.0.0"},
+		)
+		if err != nil {
+			return err
+		}
+		if result.StatusCode != http.StatusOK {
+			_ = result.Body.Close()
+			err = fmt.Errorf("download %s: status code %d", content.Name, result.StatusCode)
+			if result.StatusCode >= http.StatusInternalServerError {
+				err = fmt.Errorf("%w, %w", err, ErrUnavailable)
+			}
+			return err
+		}
+		body = result.Body
+		return nil
+	})
+	return
+}
+
+// Label 用于日志和运行记录的服务器名称
+func (a *Server) Label() string {
+	if a.Name != "" {
+		return a.Name
+	}
+	return a.Endpoint
+}
@@ -0,0 +1,118 @@
+package alist
+
+import (
+	"astrm/service/job"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// signAlist 模拟返回固定签名的 alist，listDown 为 true 时列目录返回 5xx
+func signAlist(t *testing.T, sign string, listDown bool) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/fs/list":
+			if listDown {
+				rw.WriteHeader(http.StatusBadGateway)
+				return
+			}
+			content := []Content{{Name: "movie.mkv", Size: 5, Modified: "2024-01-02T15:04:05Z", Sign: sign}}
+			_ = json.NewEncoder(rw).Encode(map[string]any{"code": 200, "message": "success", "data": map[string]any{"content": content, "total": 1}})
+		case "/api/fs/get":
+			_ = json.NewEncoder(rw).Encode(map[string]any{"code": 200, "message": "success", "data": map[string]any{"name": "movie.mkv", "sign": sign}})
+		default:
+			rw.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func runJob(t *testing.T, j *job.Job) {
+	t.Helper()
+	if _, err := j.Trigger(job.TriggerManual); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for j.Running() {
+		if time.Now().After(deadline) {
+			t.Fatal("job is still running")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestFailoverSign(t *testing.T) {
+	setDataDir(t)
+
+	// 主服务器无法列目录但可以获取签名，strm 使用主服务器的签名
+	primary := signAlist(t, "primary-sign", true)
+	backup := signAlist(t, "backup-sign", false)
+	dest := t.TempDir()
+	j := &job.Job{
+		Key:     "failover",
+		Name:    "failover",
+		From:    "/media",
+		Dest:    dest,
+		Handler: &Group{Servers: []*Server{{Endpoint: primary.URL}, {Endpoint: backup.URL}}},
+		Opts:    &job.Opts{Filters: `\.mkv$`, Index: true},
+	}
+	runJob(t, j)
+	strm := filepath.Join(dest, "movie.strm")
+	if body, err := os.ReadFile(strm); err != nil || string(body) != primary.URL+"/d/media/movie.mkv?sign=primary-sign" {
+		t.Errorf("movie.strm = %q, %v", body, err)
+	}
+
+	// 主服务器完全不可用时，索引中未变化的文件直接跳过，任务仍然成功
+	primary.Close()
+	want := primary.URL + "/d/media/movie.mkv?sign=primary-sign"
+	runJob(t, j)
+	if j.Status != "success" {
+		t.Errorf("status with an unchanged index = %q, want success", j.Status)
+	}
+	if body, err := os.ReadFile(strm); err != nil || string(body) != want {
+		t.Errorf("movie.strm = %q, %v, want it kept", body, err)
+	}
+
+	// 没有索引时，本地已是最新的 strm 同样跳过
+	if err := job.ResetIndex(j.Key); err != nil {
+		t.Fatal(err)
+	}
+	runJob(t, j)
+	if j.Status != "success" {
+		t.Errorf("status with an up-to-date strm = %q, want success", j.Status)
+	}
+	if body, err := os.ReadFile(strm); err != nil || string(body) != want {
+		t.Errorf("movie.strm = %q, %v, want it kept", body, err)
+	}
+
+	// 需要写入的 strm 推迟到下次运行，不写入使用备用服务器签名的 strm，也不记录到索引中
+	if err := os.Remove(strm); err != nil {
+		t.Fatal(err)
+	}
+	if err := job.ResetIndex(j.Key); err != nil {
+		t.Fatal(err)
+	}
+	runJob(t, j)
+	if j.Status != "success" {
+		t.Errorf("status with a deferred strm = %q, want success", j.Status)
+	}
+	if runs, err := job.Runs(j.Key); err != nil || len(runs) == 0 || runs[0].Stats.Deferred != 1 || runs[0].Stats.Errors != 0 {
+		t.Errorf("last run = %+v, %v, want 1 deferred file and no errors", runs, err)
+	}
+	if _, err := os.Stat(strm); !os.IsNotExist(err) {
+		t.Errorf("movie.strm written with the backup sign: %v", err)
+	}
+	x, err := job.OpenIndex(j.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e := x.Lookup("/media/movie.mkv"); e != nil {
+		t.Errorf("index has %+v, want no entry", e)
+	}
+}
@@ -0,0 +1,192 @@
+package alist
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Limit alist 服务器的请求限速，同一服务器上的所有任务和播放请求共用
+type Limit struct {
+	List     Bucket `yaml:"list" json:"list"`         // 列目录
+	Get      Bucket `yaml:"get" json:"get"`           // 获取文件信息和直链
+	Download Bucket `yaml:"download" json:"download"` // 下载 extra 文件
+}
+
+// Bucket 令牌桶，Rate 为每秒请求数，0 表示不限制，Burst 为允许连续发出的请求数，默认 1
+type Bucket struct {
+	Rate  float64 `yaml:"rate" json:"rate"`
+	Burst int     `yaml:"burst" json:"burst"`
+}
+
+// 请求的类别，分别使用 Limit 中对应的令牌桶
+const (
+	kindList = iota
+	kindGet
+	kindDownload
+)
+
+// requestKind 按请求路径分类，uri 为相对 Endpoint 的地址，下载路径中包含 api/fs/list 等字样时仍按下载计算
+func requestKind(uri string) int {
+	p := uri
+	if u, err := url.Parse(uri); err == nil {
+		p = u.Path
+	}
+	switch "/" + strings.TrimLeft(p, "/") {
+	case "/api/fs/list":
+		return kindList
+	case "/api/fs/get":
+		return kindGet
+	default:
+		return kindDownload
+	}
+}
+
+type contextKey int
+
+const (
+	interactiveKey contextKey = iota
+	sessionKey
+	intervalKey
+	passwordKey
+)
+
+// Interactive 标记为播放等交互请求，限速时优先于后台任务
+func Interactive(ctx context.Context) context.Context {
+	return context.WithValue(ctx, interactiveKey, true)
+}
+
+func isInteractive(ctx context.Context) bool {
+	v, _ := ctx.Value(interactiveKey).(bool)
+	return v
+}
+
+type bucket struct {
+	mu      sync.Mutex
+	rate    float64
+	burst   float64
+	tokens  float64
+	last    time.Time
+	waiting int // 正在等待的交互请求数，大于 0 时后台请求让行
+}
+
+func newBucket(b Bucket) *bucket {
+	if b.Rate <= 0 {
+		return nil
+	}
+	burst := float64(b.Burst)
+	if burst < 1 {
+		burst = 1
+	}
+	return &bucket{rate: b.Rate, burst: burst, tokens: burst, last: time.Now()}
+}
+
+// wait 等待一个令牌，交互请求优先获得令牌
+func (b *bucket) wait(ctx context.Context, interactive bool) error {
+	if b == nil {
+		return nil
+	}
+	if interactive {
+		b.mu.Lock()
+		b.waiting++
+		b.mu.Unlock()
+		defer func() {
+			b.mu.Lock()
+			b.waiting--
+			b.mu.Unlock()
+		}()
+	}
+	for {
+		b.mu.Lock()
+		now := time.Now()
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+		b.last = now
+		if b.tokens >= 1 && (interactive || b.waiting == 0) {
+			b.tokens--
+			b.mu.Unlock()
+			return nil
+		}
+		delay := time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+		if delay < 10*time.Millisecond {
+			// 有令牌但需要让行给交互请求
+			delay = 10 * time.Millisecond
+		}
+		b.mu.Unlock()
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
+
+// scheduler 服务器的限速状态，配置变化后重新创建
+type scheduler struct {
+	limit   Limit
+	buckets [3]*bucket
+}
+
+// schedulers *Server -> *scheduler，不放在 Server 中以免复制配置时复制锁，配置被替换或删除时由 Forget 清除
+var schedulers sync.Map
+
+func newScheduler(limit Limit) *scheduler {
+	return &scheduler{
+		limit:   limit,
+		buckets: [3]*bucket{newBucket(limit.List), newBucket(limit.Get), newBucket(limit.Download)},
+	}
+}
+
+func (a *Server) scheduler() *scheduler {
+	var limit Limit
+	if a.Limit != nil {
+		limit = *a.Limit
+	}
+	for {
+		v, ok := schedulers.Load(a)
+		if !ok {
+			// 并发的首次请求只有一个能创建成功，其余使用同一个令牌桶
+			v, _ = schedulers.LoadOrStore(a, newScheduler(limit))
+		}
+		sc := v.(*scheduler)
+		if sc.limit == limit {
+			return sc
+		}
+		// 限速配置变化，只有一个请求能替换成功，失败时重新读取
+		if next := newScheduler(limit); schedulers.CompareAndSwap(a, sc, next) {
+			return next
+		}
+	}
+}
+
+// Forget 清除服务器的限速和登录状态，alist 配置被替换或删除后调用
+func (a *Server) Forget() {
+	schedulers.Delete(a)
+	logins.Delete(a)
+}
+
+// withInterval 设置任务单次运行的请求间隔（秒），运行中对所有服务器的列目录、获取直链和下载共用
+func withInterval(ctx context.Context, seconds float64) context.Context {
+	if seconds <= 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, intervalKey, newBucket(Bucket{Rate: 1 / seconds, Burst: 1}))
+}
+
+// wait 先等待任务的请求间隔，再按请求类别等待服务器的令牌
+func (sc *scheduler) wait(ctx context.Context, uri string) error {
+	interactive := isInteractive(ctx)
+	if b, ok := ctx.Value(intervalKey).(*bucket); ok && !interactive {
+		if err := b.wait(ctx, false); err != nil {
+			return err
+		}
+	}
+	return sc.buckets[requestKind(uri)].wait(ctx, interactive)
+}
@@ -0,0 +1,186 @@
+package alist
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestSchedulerInterval(t *testing.T) {
+	a := &Server{Endpoint: "http://alist"}
+	ctx := withInterval(context.Background(), 0.05)
+
+	// 同一次运行中并发的请求共用任务的请求间隔
+	start := time.Now()
+	done := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			var err error
+			for k := 0; k < 2 && err == nil; k++ {
+				err = a.scheduler().wait(ctx, "api/fs/list")
+			}
+			done <- err
+		}()
+	}
+	for i := 0; i < 2; i++ {
+		if err := <-done; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 140*time.Millisecond {
+		t.Errorf("4 requests took %s, want at least 150ms", elapsed)
+	}
+
+	// 其他任务的间隔单独计算，获取直链和下载同样受间隔限制
+	start = time.Now()
+	other := withInterval(context.Background(), 0.05)
+	for _, uri := range []string{"api/fs/get", "/d/media/movie.srt"} {
+		if err := a.scheduler().wait(other, uri); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond {
+		t.Errorf("another job's 2 requests took %s, want at least one interval", elapsed)
+	}
+
+	// 播放请求不受任务间隔的限制：需要等待时已取消的 ctx 会返回错误
+	long := withInterval(context.Background(), 3600)
+	if err := a.scheduler().wait(long, "api/fs/get"); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.scheduler().wait(canceled(long), "api/fs/get"); err == nil {
+		t.Error("job request did not wait for the interval")
+	}
+	for i := 0; i < 3; i++ {
+		if err := a.scheduler().wait(Interactive(canceled(long)), "api/fs/get"); err != nil {
+			t.Errorf("interactive request %d waited for the job interval: %v", i, err)
+		}
+	}
+}
+
+// canceled 返回已取消的 ctx，用于判断请求是否需要等待令牌
+func canceled(parent context.Context) context.Context {
+	ctx, cancel := context.WithCancel(parent)
+	cancel()
+	return ctx
+}
+
+func TestSchedulerLimit(t *testing.T) {
+	// 每秒 1 个令牌，测试期间不会补充
+	a := &Server{Endpoint: "http://alist", Limit: &Limit{
+		List:     Bucket{Rate: 1, Burst: 3},
+		Get:      Bucket{Rate: 1},
+		Download: Bucket{Rate: 1, Burst: 2},
+	}}
+	tests := []struct {
+		uri   string
+		burst int
+	}{
+		{"api/fs/list", 3},
+		{"api/fs/get", 1},
+		{"/d/media/movie.srt", 2},
+	}
+	// 每类请求使用各自的令牌桶，连续发出 burst 个后需要等待
+	for _, tt := range tests {
+		for i := 0; i < tt.burst; i++ {
+			if err := a.scheduler().wait(canceled(context.Background()), tt.uri); err != nil {
+				t.Errorf("%s request %d waited within burst %d: %v", tt.uri, i, tt.burst, err)
+			}
+		}
+		if err := a.scheduler().wait(canceled(context.Background()), tt.uri); err == nil {
+			t.Errorf("%s request %d did not wait after burst %d", tt.uri, tt.burst, tt.burst)
+		}
+	}
+
+	// 没有配置的类别不限速
+	free := &Server{Endpoint: "http://alist", Limit: &Limit{List: Bucket{Rate: 1}}}
+	for i := 0; i < 3; i++ {
+		if err := free.scheduler().wait(canceled(context.Background()), "api/fs/get"); err != nil {
+			t.Errorf("unlimited request %d waited: %v", i, err)
+		}
+	}
+}
+
+func TestSchedulerPriority(t *testing.T) {
+	a := &Server{Endpoint: "http://alist", Limit: &Limit{Get: Bucket{Rate: 5}}}
+	if err := a.scheduler().wait(context.Background(), "api/fs/get"); err != nil {
+		t.Fatal(err)
+	}
+
+	// 任务请求先开始等待，播放请求后到但先拿到下一个令牌
+	order := make(chan string, 2)
+	go func() {
+		if err := a.scheduler().wait(context.Background(), "api/fs/get"); err == nil {
+			order <- "job"
+		}
+	}()
+	time.Sleep(20 * time.Millisecond)
+	go func() {
+		if err := a.scheduler().wait(Interactive(context.Background()), "api/fs/get"); err == nil {
+			order <- "interactive"
+		}
+	}()
+	for _, want := range []string{"interactive", "job"} {
+		select {
+		case got := <-order:
+			if got != want {
+				t.Fatalf("got %s request first, want %s", got, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for the %s request", want)
+		}
+	}
+}
+
+func TestSchedulerShared(t *testing.T) {
+	a := &Server{Endpoint: "http://alist", Limit: &Limit{List: Bucket{Rate: 1}}}
+
+	// 并发的首次请求共用同一个限速状态
+	got := make(chan *scheduler, 8)
+	for i := 0; i < cap(got); i++ {
+		go func() { got <- a.scheduler() }()
+	}
+	first := <-got
+	for i := 1; i < cap(got); i++ {
+		if sc := <-got; sc != first {
+			t.Fatal("concurrent first requests created different schedulers")
+		}
+	}
+
+	// 限速配置变化后重新创建
+	a.Limit = &Limit{List: Bucket{Rate: 2}}
+	if sc := a.scheduler(); sc == first || sc.limit != *a.Limit {
+		t.Error("scheduler was not recreated after the limit changed")
+	}
+
+	a.Forget()
+	if _, ok := schedulers.Load(a); ok {
+		t.Error("Forget() kept the scheduler")
+	}
+	if _, ok := logins.Load(a); ok {
+		t.Error("Forget() kept the login")
+	}
+}
+
+func TestRequestKind(t *testing.T) {
+	tests := []struct {
+		uri  string
+		want int
+	}{
+		{"api/fs/list", kindList},
+		{"/api/fs/list", kindList},
+		{"api/fs/get", kindGet},
+		{"/api/fs/get?x=1", kindGet},
+		{"/d/media/movie.srt", kindDownload},
+		// 下载路径中包含 api 路径时仍按下载计算
+		{"/d/media/api/fs/list/a.srt", kindDownload},
+		{"/d/api/fs/get", kindDownload},
+		{"/p/media/api/fs/list", kindDownload},
+		{"api/fs/listing", kindDownload},
+	}
+	for _, tt := range tests {
+		if got := requestKind(tt.uri); got != tt.want {
+			t.Errorf("requestKind(%q) = %d, want %d", tt.uri, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package alist
+
+import (
+	"context"
+	"path"
+	"strings"
+)
+
+type listRequest struct {
+	Path     string `json:"path"`
+	Password string `json:"password"`
+	Page     int    `json:"page"`
+	PerPage  int    `json:"per_page"`
+	Refresh  bool   `json:"refresh"`
+}
+
+type getRequest struct {
+	Path     string `json:"path"`
+	Password string `json:"password"`
+}
+
+// PathPassword alist 中设置了访问密码的目录，对该目录及其子目录下的请求生效
+type PathPassword struct {
+	Path     string `yaml:"path" json:"path"`
+	Password string `yaml:"password" json:"password"`
+}
+
+// password 按最长前缀匹配 p 使用的目录密码，没有匹配的规则时返回空
+func (a *Server) password(p string) (password string) {
+	p = path.Clean("/" + p)
+	longest := -1
+	for _, rule := range a.Passwords {
+		prefix := path.Clean("/" + rule.Path)
+		if p != prefix && prefix != "/" && !strings.HasPrefix(p, prefix+"/") {
+			continue
+		}
+		if len(prefix) > longest {
+			longest, password = len(prefix), rule.Password
+		}
+	}
+	return
+}
+
+// WithPassword 指定本次浏览使用的目录密码，优先于配置的规则
+func WithPassword(ctx context.Context, password string) context.Context {
+	return context.WithValue(ctx, passwordKey, password)
+}
+
+func (a *Server) passwordFor(ctx context.Context, p string) string {
+	if password, _ := ctx.Value(passwordKey).(string); password != "" {
+		return password
+	}
+	return a.password(p)
+}
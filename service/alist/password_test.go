@@ -0,0 +1,52 @@
+package alist
+
+import (
+	"context"
+	"testing"
+)
+
+func TestPassword(t *testing.T) {
+	a := &Server{Passwords: []PathPassword{
+		{Path: "/a", Password: "a"},
+		{Path: "/a/b/", Password: "ab"},
+		{Path: "/x", Password: "x"},
+	}}
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/a", "a"},
+		{"/a/c.mkv", "a"},
+		// 最长前缀优先，与规则的顺序无关
+		{"/a/b", "ab"},
+		{"/a/b/c/d.mkv", "ab"},
+		{"a/b/c.mkv", "ab"},
+		// 只在路径分段边界匹配
+		{"/ab", ""},
+		{"/ab/c.mkv", ""},
+		{"/a/bc/d.mkv", "a"},
+		{"/other/c.mkv", ""},
+		{"/", ""},
+	}
+	for _, tt := range tests {
+		if got := a.password(tt.path); got != tt.want {
+			t.Errorf("password(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+
+	// 根目录的规则对所有路径生效，更具体的规则优先
+	root := &Server{Passwords: []PathPassword{{Path: "/a", Password: "a"}, {Path: "/", Password: "root"}}}
+	for p, want := range map[string]string{"/b/c.mkv": "root", "/a/c.mkv": "a", "/ab": "root"} {
+		if got := root.password(p); got != want {
+			t.Errorf("root password(%q) = %q, want %q", p, got, want)
+		}
+	}
+
+	// 浏览时指定的密码优先于规则
+	if got := a.passwordFor(WithPassword(context.Background(), "given"), "/a/b"); got != "given" {
+		t.Errorf("passwordFor() with WithPassword = %q, want given", got)
+	}
+	if got := (&Server{}).passwordFor(context.Background(), "/a"); got != "" {
+		t.Errorf("passwordFor() without rules = %q, want empty", got)
+	}
+}
@@ -1,13 +1,16 @@
 package emby
 
 import (
+	"bytes"
 	"encoding/json"
+	"fmt"
 	"io"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type EmbyServer struct {
@@ -109,3 +112,44 @@ func New(addr string, apiKey string) *EmbyServer {
 	emby.Init()
 	return emby
 }
+
+// LibraryService
+// /Library/Media/Updated
+//
+// 通知 Emby 指定路径下的媒体发生了变化，Emby 只会扫描这些路径
+func (embyServer *EmbyServer) LibraryMediaUpdated(paths []string) error {
+	updates := make([]MediaUpdateInfo, 0, len(paths))
+	for _, p := range paths {
+		updates = append(updates, MediaUpdateInfo{Path: p, UpdateType: "Modified"})
+	}
+	body, err := json.Marshal(MediaUpdatesRequest{Updates: updates})
+	if err != nil {
+		return err
+	}
+	return embyServer.post("/Library/Media/Updated", body)
+}
+
+// LibraryService
+// /Library/Refresh
+//
+// 扫描全部媒体库
+func (embyServer *EmbyServer) LibraryRefresh() error {
+	return embyServer.post("/Library/Refresh", nil)
+}
+
+func (embyServer *EmbyServer) post(path string, body []byte) error {
+	params := url.Values{}
+	params.Add("api_key", embyServer.GetAPIKey())
+	api := embyServer.GetEndpoint() + path + "?" + params.Encode()
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Post(api, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= http.StatusBadRequest {
+		msg, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("POST %s: %s %s", path, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
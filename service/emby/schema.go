@@ -5,6 +5,16 @@ type EmbyResponse struct {
 	TotalRecordCount *int64        `json:"TotalRecordCount,omitempty"`
 }
 
+// /Library/Media/Updated的请求
+type MediaUpdatesRequest struct {
+	Updates []MediaUpdateInfo `json:"Updates"`
+}
+
+type MediaUpdateInfo struct {
+	Path       string `json:"Path"`
+	UpdateType string `json:"UpdateType"` // Created, Modified, Deleted
+}
+
 // /Items/:itemID/PlaybackInfo的响应
 type PlaybackInfoResponse struct {
 	ErrorCode     *PlaybackErrorCode `json:"ErrorCode,omitempty"`
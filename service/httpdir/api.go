@@ -0,0 +1,238 @@
+package httpdir
+
+import (
+	"astrm/service/job"
+	"astrm/utils"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Server HTTP 目录索引源端，支持 nginx autoindex（html/json）、caddy file_server browse 以及常见的 html 目录页
+type Server struct {
+	Id        int               `yaml:"-" json:"id"`
+	Name      string            `yaml:"name" json:"name,omitempty"`
+	Endpoint  string            `yaml:"endpoint" json:"endpoint,omitempty"` // 目录索引的根地址，如 http://nas:8080/media
+	Username  string            `yaml:"username" json:"username,omitempty"` // basic auth，可选
+	Password  string            `yaml:"password" json:"password,omitempty"`
+	Headers   map[string]string `yaml:"headers" json:"headers,omitempty"`     // 额外的请求头
+	PublicURL string            `yaml:"publicUrl" json:"publicUrl,omitempty"` // strm 中使用的地址前缀，不写表示使用 Endpoint
+
+	Client *http.Client `yaml:"-" json:"-"`
+}
+
+// jsonItem nginx autoindex_format json 和 caddy browse 的列表项
+type jsonItem struct {
+	Name    string `json:"name"`
+	Type    string `json:"type"`  // nginx: directory / file
+	Mtime   string `json:"mtime"` // nginx: RFC1123
+	Size    int64  `json:"size"`
+	IsDir   bool   `json:"is_dir"`   // caddy
+	ModTime string `json:"mod_time"` // caddy: RFC3339
+}
+
+var (
+	linkRegex = regexp.MustCompile(`(?is)<a\s[^>]*?href\s*=\s*["']([^"']+)["'][^>]*>.*?</a>`)
+	tagRegex  = regexp.MustCompile(`<[^>]*>`)
+	// nginx / apache 目录页中链接后面的修改时间和大小，例如 "01-Jan-2024 00:00    12345"、"2024-01-01 00:00  1.2M"
+	infoRegex = regexp.MustCompile(`(\d{2}-\w{3}-\d{4} \d{2}:\d{2}(?::\d{2})?|\d{4}-\d{2}-\d{2} \d{2}:\d{2}(?::\d{2})?)\s+(\d+(?:\.\d+)?[KMGT]?|-)`)
+)
+
+func (h *Server) Handle(s *job.Session) error {
+	return job.Walk(s, h)
+}
+
+func (h *Server) client() *http.Client {
+	if h.Client != nil {
+		return h.Client
+	}
+	return &http.Client{Timeout: 30 * time.Second}
+}
+
+func (h *Server) url(base, p string) (*url.URL, error) {
+	u, err := url.Parse(strings.TrimSpace(base))
+	if err != nil {
+		return nil, err
+	}
+	u.Path = path.Join("/", u.Path, p)
+	u.RawPath = ""
+	return u, nil
+}
+
+func (h *Server) get(ctx context.Context, client *http.Client, u *url.URL, accept string) (res *http.Response, err error) {
+	var req *http.Request
+	if req, err = http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil); err != nil {
+		return
+	}
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	for k, v := range h.Headers {
+		req.Header.Set(k, v)
+	}
+	if h.Username != "" {
+		req.SetBasicAuth(h.Username, h.Password)
+	}
+	if res, err = client.Do(req); err != nil {
+		return
+	}
+	if res.StatusCode != http.StatusOK {
+		_ = res.Body.Close()
+		return nil, fmt.Errorf("GET %s: status code %d", u.Path, res.StatusCode)
+	}
+	return
+}
+
+// List 读取目录索引页，优先解析 JSON，否则解析 HTML 中的链接
+func (h *Server) List(ctx context.Context, dir string) (entries []*job.Entry, err error) {
+	var u *url.URL
+	if u, err = h.url(h.Endpoint, dir); err != nil {
+		return
+	}
+	u.Path += "/"
+	var res *http.Response
+	if res, err = h.get(ctx, h.client(), u, "application/json, text/html;q=0.9"); err != nil {
+		return
+	}
+	defer func(Body io.ReadCloser) {
+		_ = Body.Close()
+	}(res.Body)
+	var body []byte
+	if body, err = io.ReadAll(res.Body); err != nil {
+		return
+	}
+
+	dir = path.Join("/", dir)
+	if trimmed := bytes.TrimSpace(body); len(trimmed) > 0 && trimmed[0] == '[' {
+		return parseJson(dir, trimmed)
+	}
+	return parseHtml(dir, u, body), nil
+}
+
+func parseJson(dir string, body []byte) (entries []*job.Entry, err error) {
+	var items []jsonItem
+	if err = json.Unmarshal(body, &items); err != nil {
+		return nil, fmt.Errorf("list %s: %w", dir, err)
+	}
+	for _, item := range items {
+		name := strings.TrimSuffix(item.Name, "/")
+		if name == "" || name == "." || name == ".." {
+			continue
+		}
+		e := &job.Entry{
+			Path:  path.Join(dir, name),
+			IsDir: item.IsDir || item.Type == "directory" || strings.HasSuffix(item.Name, "/"),
+			Size:  item.Size,
+		}
+		if t, parseErr := http.ParseTime(item.Mtime); parseErr == nil {
+			e.Modified = t
+		} else if t, parseErr = time.Parse(time.RFC3339Nano, item.ModTime); parseErr == nil {
+			e.Modified = t
+		}
+		entries = append(entries, e)
+	}
+	return
+}
+
+func parseHtml(dir string, base *url.URL, body []byte) (entries []*job.Entry) {
+	seen := map[string]struct{}{}
+	links := linkRegex.FindAllSubmatchIndex(body, -1)
+	for i, m := range links {
+		href, err := url.Parse(html.UnescapeString(string(body[m[2]:m[3]])))
+		if err != nil || href.RawQuery != "" || (href.Path == "" && href.Fragment != "") {
+			// 排序链接、锚点等
+			continue
+		}
+		target := base.ResolveReference(href)
+		if target.Host != base.Host || !strings.HasPrefix(target.Path, base.Path) {
+			// 上级目录或外部链接
+			continue
+		}
+		rel := strings.TrimPrefix(target.Path, base.Path)
+		isDir := strings.HasSuffix(rel, "/")
+		rel = strings.TrimSuffix(rel, "/")
+		if rel == "" || strings.Contains(rel, "/") {
+			continue
+		}
+		if _, ok := seen[rel]; ok {
+			continue
+		}
+		seen[rel] = struct{}{}
+
+		e := &job.Entry{Path: path.Join(dir, rel), IsDir: isDir}
+		end := len(body)
+		if i+1 < len(links) {
+			end = links[i+1][0]
+		}
+		e.Modified, e.Size = linkInfo(body[m[1]:end])
+		entries = append(entries, e)
+	}
+	return
+}
+
+// linkInfo 解析链接后面同一行（或表格行）中的修改时间和大小，
+// nginx 在 <pre> 中以空格分隔，apache 的 HTMLTable 在后面的单元格中
+func linkInfo(tail []byte) (modified time.Time, size int64) {
+	for _, sep := range [][]byte{[]byte("\n"), []byte("</tr>"), []byte("</TR>")} {
+		if i := bytes.Index(tail, sep); i >= 0 {
+			tail = tail[:i]
+		}
+	}
+	info := infoRegex.FindSubmatch(tagRegex.ReplaceAll(tail, []byte(" ")))
+	if info == nil {
+		return
+	}
+	for _, layout := range []string{"02-Jan-2006 15:04", "02-Jan-2006 15:04:05", "2006-01-02 15:04", "2006-01-02 15:04:05"} {
+		if t, err := time.Parse(layout, string(info[1])); err == nil {
+			modified = t
+			break
+		}
+	}
+	return modified, parseSize(string(info[2]))
+}
+
+// parseSize 解析字节数，apache、nginx（autoindex_exact_size off）显示的 1.2K、3M 等近似大小和 "-" 返回 0，
+// 表示大小未知：extra 文件按大小校验，近似值会导致校验失败
+func parseSize(s string) int64 {
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0
+	}
+	return n
+}
+
+// Strm 返回文件的地址
+func (h *Server) Strm(_ context.Context, e *job.Entry) (string, error) {
+	base := h.PublicURL
+	if base == "" {
+		base = h.Endpoint
+	}
+	u, err := h.url(base, e.Path)
+	if err != nil {
+		return "", err
+	}
+	return u.String(), nil
+}
+
+// Open 下载 extra 文件
+func (h *Server) Open(ctx context.Context, e *job.Entry) (io.ReadCloser, error) {
+	u, err := h.url(h.Endpoint, e.Path)
+	if err != nil {
+		return nil, err
+	}
+	res, err := h.get(ctx, utils.DownloadClient(h.Client), u, "")
+	if err != nil {
+		return nil, err
+	}
+	return res.Body, nil
+}
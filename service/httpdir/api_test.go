@@ -0,0 +1,236 @@
+package httpdir
+
+import (
+	"astrm/service/job"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// nginx autoindex 的原始输出，长文件名显示时被截断为 "..&gt;"，href 中是完整的编码后名称
+const nginxHtml = `<html>
+<head><title>Index of /media/movie/</title></head>
+<body>
+<h1>Index of /media/movie/</h1><hr><pre><a href="../">../</a>
+<a href="Show/">Show/</a>                                              02-Jan-2024 07:04                   -
+<a href="Tom%20%26%20Jerry%20(1940).mkv">Tom &amp; Jerry (1940).mkv</a>                          02-Jan-2024 07:04:05           1048576
+<a href="%E7%94%B5%E5%BD%B1%20(2020).mp4">电影 (2020).mp4</a>                                    15-Mar-2023 20:30            2048
+<a href="A.Very.Long.Movie.Name.That.Nginx.Truncates.2024.1080p.WEB-DL.mkv">A.Very.Long.Movie.Name.That.Nginx.Truncates.2..&gt;</a> 02-Jan-2024 07:04      734003200
+</pre><hr></body>
+</html>
+`
+
+// apache 2.4 FancyIndexing + HTMLTable，表头是排序链接，修改时间和大小在后面的单元格中
+const apacheHtml = `<!DOCTYPE HTML PUBLIC "-//W3C//DTD HTML 3.2 Final//EN">
+<html>
+ <head>
+  <title>Index of /media/movie</title>
+ </head>
+ <body>
+<h1>Index of /media/movie</h1>
+  <table>
+   <tr><th valign="top"><img src="/icons/blank.gif" alt="[ICO]"></th><th><a href="?C=N;O=D">Name</a></th><th><a href="?C=M;O=A">Last modified</a></th><th><a href="?C=S;O=A">Size</a></th><th><a href="?C=D;O=A">Description</a></th></tr>
+   <tr><th colspan="5"><hr></th></tr>
+<tr><td valign="top"><img src="/icons/back.gif" alt="[PARENTDIR]"></td><td><a href="/media/">Parent Directory</a></td><td>&nbsp;</td><td align="right">  - </td><td>&nbsp;</td></tr>
+<tr><td valign="top"><img src="/icons/folder.gif" alt="[DIR]"></td><td><a href="Show/">Show/</a></td><td align="right">2024-01-02 07:04  </td><td align="right">  - </td><td>&nbsp;</td></tr>
+<tr><td valign="top"><img src="/icons/movie.gif" alt="[VID]"></td><td><a href="Tom%20&amp;%20Jerry%20%5B1080p%5D.mkv">Tom &amp; Jerry [1080p].mkv</a></td><td align="right">2024-01-02 07:04  </td><td align="right">1.5M</td><td>&nbsp;</td></tr>
+<tr><td valign="top"><img src="/icons/text.gif" alt="[TXT]"></td><td><a href="readme.txt">readme.txt</a></td><td align="right">2023-03-15 20:30  </td><td align="right">120 </td><td>&nbsp;</td></tr>
+   <tr><th colspan="5"><hr></th></tr>
+</table>
+<address>Apache/2.4.57 (Debian) Server at example.com Port 80</address>
+</body></html>
+`
+
+// caddy file_server browse 的 HTML，链接带 ./ 前缀，另有面包屑、排序和外部链接
+const caddyHtml = `<!DOCTYPE html>
+<html>
+<body>
+<header>
+<h1><a href="/">/</a><a href="/media/">media</a>/<a href="/media/movie/">movie</a>/</h1>
+</header>
+<main>
+<table>
+<thead><tr>
+<th><a href="?sort=name&amp;order=desc">Name</a></th>
+<th><a href="?sort=size&amp;order=asc">Size</a></th>
+</tr></thead>
+<tbody>
+<tr class="file"><td><a href="../">Up</a></td></tr>
+<tr class="file">
+<td><a href="./Show/"><span class="name">Show</span></a></td>
+<td data-order="-1">&mdash;</td>
+<td class="timestamp"><time datetime="2024-01-02T07:04:05Z">01/02/2024 07:04:05 AM +00:00</time></td>
+</tr>
+<tr class="file">
+<td><a href="./%E7%94%B5%E5%BD%B1%20%232.mp4"><span class="name">电影 #2.mp4</span></a></td>
+<td class="size" data-order="2048"><div class="sizebar"><span>2.0 KiB</span></div></td>
+</tr>
+</tbody>
+</table>
+</main>
+<footer>Served with <a rel="noopener noreferrer" href="https://caddyserver.com">Caddy</a></footer>
+</body>
+</html>
+`
+
+func TestParseHtml(t *testing.T) {
+	date := func(s string) time.Time {
+		t, _ := time.Parse("2006-01-02 15:04:05", s)
+		return t
+	}
+	tests := []struct {
+		name string
+		base string
+		body string
+		want []*job.Entry
+	}{
+		{
+			name: "nginx",
+			base: "http://example.com/media/movie/",
+			body: nginxHtml,
+			want: []*job.Entry{
+				{Path: "/movie/Show", IsDir: true, Modified: date("2024-01-02 07:04:00")},
+				{Path: "/movie/Tom & Jerry (1940).mkv", Size: 1048576, Modified: date("2024-01-02 07:04:05")},
+				{Path: "/movie/电影 (2020).mp4", Size: 2048, Modified: date("2023-03-15 20:30:00")},
+				{Path: "/movie/A.Very.Long.Movie.Name.That.Nginx.Truncates.2024.1080p.WEB-DL.mkv", Size: 734003200, Modified: date("2024-01-02 07:04:00")},
+			},
+		},
+		{
+			name: "apache",
+			base: "http://example.com/media/movie/",
+			body: apacheHtml,
+			want: []*job.Entry{
+				{Path: "/movie/Show", IsDir: true, Modified: date("2024-01-02 07:04:00")},
+				{Path: "/movie/Tom & Jerry [1080p].mkv", Modified: date("2024-01-02 07:04:00")}, // 近似大小 1.5M 视为未知
+				{Path: "/movie/readme.txt", Size: 120, Modified: date("2023-03-15 20:30:00")},
+			},
+		},
+		{
+			name: "caddy",
+			base: "http://example.com/media/movie/",
+			body: caddyHtml,
+			want: []*job.Entry{
+				{Path: "/movie/Show", IsDir: true},
+				{Path: "/movie/电影 #2.mp4"},
+			},
+		},
+		{
+			// 重复的链接只保留一个，子目录中的链接和锚点忽略
+			name: "duplicate and nested",
+			base: "http://example.com/",
+			body: `<a href="#top">top</a><a href="a.mkv"><img src="icon.png"></a><a href='a.mkv'>a.mkv</a><a href="dir/b.mkv">b.mkv</a><a href="http://example.com/c.mkv">c.mkv</a>`,
+			want: []*job.Entry{
+				{Path: "/a.mkv"},
+				{Path: "/c.mkv"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			base, err := url.Parse(tt.base)
+			if err != nil {
+				t.Fatal(err)
+			}
+			dir := "/movie"
+			if base.Path == "/" {
+				dir = "/"
+			}
+			got := parseHtml(dir, base, []byte(tt.body))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseHtml() =")
+				for _, e := range got {
+					t.Errorf("  %+v", *e)
+				}
+				t.Errorf("want")
+				for _, e := range tt.want {
+					t.Errorf("  %+v", *e)
+				}
+			}
+		})
+	}
+}
+
+func TestParseJson(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want []*job.Entry
+	}{
+		{
+			// nginx autoindex_format json
+			name: "nginx",
+			body: `[
+{ "name":"Show", "type":"directory", "mtime":"Tue, 02 Jan 2024 07:04:05 GMT" },
+{ "name":"Tom & Jerry (1940).mkv", "type":"file", "mtime":"Tue, 02 Jan 2024 07:04:05 GMT", "size":1048576 },
+{ "name":"电影 (2020).mp4", "type":"file", "mtime":"Wed, 15 Mar 2023 20:30:00 GMT", "size":2048 },
+{ "name":"link", "type":"other", "mtime":"Wed, 15 Mar 2023 20:30:00 GMT" }
+]`,
+			want: []*job.Entry{
+				{Path: "/movie/Show", IsDir: true, Modified: time.Date(2024, 1, 2, 7, 4, 5, 0, time.UTC)},
+				{Path: "/movie/Tom & Jerry (1940).mkv", Size: 1048576, Modified: time.Date(2024, 1, 2, 7, 4, 5, 0, time.UTC)},
+				{Path: "/movie/电影 (2020).mp4", Size: 2048, Modified: time.Date(2023, 3, 15, 20, 30, 0, 0, time.UTC)},
+				{Path: "/movie/link", Modified: time.Date(2023, 3, 15, 20, 30, 0, 0, time.UTC)},
+			},
+		},
+		{
+			// caddy browse 以 Accept: application/json 请求时的输出
+			name: "caddy",
+			body: `[{"name":"Show/","size":4096,"url":"./Show/","mod_time":"2024-01-02T07:04:05.5Z","mode":2147484141,"is_dir":true,"is_symlink":false},` +
+				`{"name":"电影 #2.mp4","size":2048,"url":"./%E7%94%B5%E5%BD%B1%20%232.mp4","mod_time":"2023-03-15T20:30:00Z","mode":420,"is_dir":false,"is_symlink":false}]`,
+			want: []*job.Entry{
+				{Path: "/movie/Show", IsDir: true, Size: 4096, Modified: time.Date(2024, 1, 2, 7, 4, 5, 5e8, time.UTC)},
+				{Path: "/movie/电影 #2.mp4", Size: 2048, Modified: time.Date(2023, 3, 15, 20, 30, 0, 0, time.UTC)},
+			},
+		},
+		{
+			name: "skip dot entries",
+			body: `[{"name":".","type":"directory"},{"name":"..","type":"directory"},{"name":"","type":"file"},{"name":"a.mkv","type":"file","size":1}]`,
+			want: []*job.Entry{
+				{Path: "/movie/a.mkv", Size: 1},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseJson("/movie", []byte(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseJson() =")
+				for _, e := range got {
+					t.Errorf("  %+v", *e)
+				}
+				t.Errorf("want")
+				for _, e := range tt.want {
+					t.Errorf("  %+v", *e)
+				}
+			}
+		})
+	}
+
+	if _, err := parseJson("/movie", []byte(`<html></html>`)); err == nil {
+		t.Error("parseJson(html) should return an error")
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		s    string
+		want int64
+	}{
+		{"120", 120},
+		{"734003200", 734003200},
+		// 近似大小无法按字节校验，视为未知
+		{"1.5M", 0},
+		{"12K", 0},
+		{"1.5MB", 0},
+		{"-", 0},
+	}
+	for _, tt := range tests {
+		if got := parseSize(tt.s); got != tt.want {
+			t.Errorf("parseSize(%q) = %d, want %d", tt.s, got, tt.want)
+		}
+	}
+}
@@ -1,5 +1,5 @@
 package job
 
 type Handler interface {
-	Handle(job *Job) error
+	Handle(s *Session) error
 }
@@ -0,0 +1,71 @@
+package job
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// bandwidth 限制一次运行中所有 extra 下载的总速度
+type bandwidth struct {
+	mu   sync.Mutex
+	rate float64 // 字节每秒
+	next time.Time
+}
+
+func newBandwidth(kbps float64) *bandwidth {
+	if kbps <= 0 {
+		return nil
+	}
+	return &bandwidth{rate: kbps * 1024}
+}
+
+// wait 为 n 字节预留发送时间，并等待到预留的时间
+func (b *bandwidth) wait(ctx context.Context, n int) error {
+	b.mu.Lock()
+	now := time.Now()
+	if b.next.Before(now) {
+		b.next = now
+	}
+	delay := b.next.Sub(now)
+	b.next = b.next.Add(time.Duration(float64(n) / b.rate * float64(time.Second)))
+	b.mu.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// reader 返回限速后的 Reader
+func (b *bandwidth) reader(ctx context.Context, r io.ReadCloser) io.ReadCloser {
+	return &limitedReader{ReadCloser: r, ctx: ctx, b: b}
+}
+
+type limitedReader struct {
+	io.ReadCloser
+	ctx context.Context
+	b   *bandwidth
+}
+
+func (r *limitedReader) Read(p []byte) (n int, err error) {
+	// 分小块读取，使速度更平滑
+	if len(p) > 32*1024 {
+		p = p[:32*1024]
+	}
+	n, err = r.ReadCloser.Read(p)
+	if n > 0 {
+		if waitErr := r.b.wait(r.ctx, n); waitErr != nil {
+			return n, waitErr
+		}
+	}
+	return
+}
@@ -0,0 +1,336 @@
+package job
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	trashLayout       = "20060102-150405"
+	defaultCleanLimit = 0.5
+)
+
+// Cleaner 同步模式下记录本次运行在源端仍然存在的文件，遍历结束后删除本地多余的 strm 及附属文件
+//
+// 只清理任务自己写入的文件：写入过的文件记录在任务的清单中，
+// 目标目录中其他工具生成的文件（如刮削的 nfo 和海报）不会被删除
+type Cleaner struct {
+	mu     sync.Mutex
+	path   string
+	roots  map[string]struct{}
+	keep   map[string]struct{}
+	owned  map[string]struct{} // 清单中记录的、之前运行写入的文件
+	wrote  map[string]struct{} // 本次运行写入的文件
+	failed bool
+}
+
+type manifest struct {
+	Files []string `json:"files"` // 任务写入的本地文件
+}
+
+func manifestPath(key string) string {
+	return filepath.Join(DataDir, "manifest", key+".json")
+}
+
+// OpenCleaner 加载任务的文件清单，不存在或损坏时返回空清单
+func OpenCleaner(key string) (c *Cleaner, err error) {
+	c = &Cleaner{
+		path:  manifestPath(key),
+		roots: map[string]struct{}{},
+		keep:  map[string]struct{}{},
+		owned: map[string]struct{}{},
+		wrote: map[string]struct{}{},
+	}
+	var bytes []byte
+	if bytes, err = os.ReadFile(c.path); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			err = nil
+		}
+		return
+	}
+	var m manifest
+	if err = json.Unmarshal(bytes, &m); err != nil {
+		return
+	}
+	for _, p := range m.Files {
+		c.owned[filepath.Clean(p)] = struct{}{}
+	}
+	return
+}
+
+// Root 登记需要清理的本地目录
+func (c *Cleaner) Root(dir string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.roots[filepath.Clean(dir)] = struct{}{}
+}
+
+// Keep 登记源端仍然存在的文件对应的本地路径
+func (c *Cleaner) Keep(path string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.keep[filepath.Clean(path)] = struct{}{}
+}
+
+// Wrote 登记本次运行写入的文件，如生成的 nfo
+func (c *Cleaner) Wrote(path string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.wrote[filepath.Clean(path)] = struct{}{}
+}
+
+// Fail 标记遍历过程中出现了错误，此时源端列表不完整，不能进行清理
+func (c *Cleaner) Fail() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.failed = true
+}
+
+// Clean 删除（或移动到回收站）任务写入过、但不再被源端文件对应的本地文件以及空目录，返回被清理的文件列表
+//
+// dryRun 为 true 时只返回将要清理的文件，不做任何修改，否则在结束时保存新的清单
+func (c *Cleaner) Clean(opts *Opts, dest string, dryRun bool) (removed []string, err error) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// 任务拥有的文件：之前写入的、本次写入的，以及源端仍然存在的文件对应的本地文件
+	owned := map[string]struct{}{}
+	for _, set := range []map[string]struct{}{c.owned, c.wrote, c.keep} {
+		for p := range set {
+			if _, statErr := os.Stat(p); statErr == nil {
+				owned[p] = struct{}{}
+			}
+		}
+	}
+	if !dryRun {
+		defer func() {
+			for _, p := range removed {
+				delete(owned, p)
+			}
+			if saveErr := c.save(owned); saveErr != nil {
+				logrus.Errorf("[Clean] save manifest error: %v", saveErr)
+			}
+		}()
+	}
+
+	if c.failed {
+		return nil, fmt.Errorf("[Clean] listing is incomplete, skip cleaning %s", dest)
+	}
+
+	// 整理目录时生成的 nfo 跟随同名的 strm，tvshow.nfo 跟随剧集目录中的文件
+	var keepDirs map[string]struct{}
+	if opts.Organize {
+		keepDirs = map[string]struct{}{}
+		for p := range c.keep {
+			for dir := filepath.Dir(p); ; dir = filepath.Dir(dir) {
+				if _, ok := keepDirs[dir]; ok {
+					break
+				}
+				keepDirs[dir] = struct{}{}
+				if filepath.Dir(dir) == dir {
+					break
+				}
+			}
+		}
+	}
+	kept := func(p string) bool {
+		if _, ok := c.keep[p]; ok {
+			return true
+		}
+		if keepDirs == nil || !strings.EqualFold(filepath.Ext(p), ".nfo") {
+			return false
+		}
+		if strings.EqualFold(filepath.Base(p), "tvshow.nfo") {
+			_, ok := keepDirs[filepath.Dir(p)]
+			return ok
+		}
+		_, ok := c.keep[strings.TrimSuffix(p, filepath.Ext(p))+".strm"]
+		return ok
+	}
+	underRoot := func(p string) bool {
+		for root := range c.roots {
+			if within(root, p) {
+				return true
+			}
+		}
+		return false
+	}
+
+	// 只在本次运行的目录中清理
+	var total int
+	var orphans []string
+	for p := range owned {
+		if !underRoot(p) {
+			continue
+		}
+		total++
+		if !kept(p) {
+			orphans = append(orphans, p)
+		}
+	}
+
+	if len(orphans) == 0 {
+		return
+	}
+
+	limit := opts.CleanLimit
+	if limit <= 0 {
+		limit = defaultCleanLimit
+	}
+	if ratio := float64(len(orphans)) / float64(total); ratio > limit {
+		return nil, fmt.Errorf("[Clean] %d of %d files would be removed (%.0f%% > %.0f%%), abort", len(orphans), total, ratio*100, limit*100)
+	}
+
+	sort.Strings(orphans)
+	if dryRun {
+		return orphans, nil
+	}
+
+	stamp := time.Now().Format(trashLayout)
+	for _, p := range orphans {
+		if opts.Trash != "" {
+			rel, relErr := filepath.Rel(dest, p)
+			if relErr != nil || strings.HasPrefix(rel, "..") {
+				rel = filepath.Base(p)
+			}
+			err = moveFile(p, filepath.Join(opts.Trash, stamp, rel))
+		} else {
+			err = os.Remove(p)
+		}
+		if err != nil {
+			logrus.Errorf("[Clean] remove %s error: %v", p, err)
+			continue
+		}
+		logrus.Infof("[Clean] %s", p)
+		removed = append(removed, p)
+	}
+	err = nil
+
+	// 只删除因本次清理而变空的上级目录，不删除根目录、回收站和用户自己创建的空目录
+	for _, p := range removed {
+		removeEmptyParents(filepath.Dir(p), func(dir string) bool {
+			_, isRoot := c.roots[dir]
+			return isRoot || !underRoot(dir) || opts.Trash != "" && within(filepath.Clean(opts.Trash), dir)
+		})
+	}
+
+	if opts.Trash != "" && opts.TrashDays > 0 {
+		purgeTrash(opts.Trash, time.Duration(opts.TrashDays)*24*time.Hour)
+	}
+	return
+}
+
+// save 保存任务拥有的文件清单
+func (c *Cleaner) save(owned map[string]struct{}) (err error) {
+	m := manifest{Files: make([]string, 0, len(owned))}
+	for p := range owned {
+		m.Files = append(m.Files, p)
+	}
+	sort.Strings(m.Files)
+
+	var bytes []byte
+	if bytes, err = json.Marshal(m); err != nil {
+		return
+	}
+	if err = os.MkdirAll(filepath.Dir(c.path), os.ModePerm); err != nil {
+		return
+	}
+	tmp := c.path + ".tmp"
+	if err = os.WriteFile(tmp, bytes, 0644); err != nil {
+		return
+	}
+	return os.Rename(tmp, c.path)
+}
+
+// moveFile 移动文件，跨设备时退化为复制后删除
+func moveFile(src, dst string) (err error) {
+	if err = os.MkdirAll(filepath.Dir(dst), os.ModePerm); err != nil {
+		return
+	}
+	if err = os.Rename(src, dst); err == nil {
+		return
+	}
+
+	var in, out *os.File
+	if in, err = os.Open(src); err != nil {
+		return
+	}
+	defer func(in *os.File) {
+		_ = in.Close()
+	}(in)
+	if out, err = os.Create(dst); err != nil {
+		return
+	}
+	if _, err = io.Copy(out, in); err != nil {
+		_ = out.Close()
+		return
+	}
+	if err = out.Close(); err != nil {
+		return
+	}
+	return os.Remove(src)
+}
+
+// within 判断 p 是否为 dir 或位于 dir 之下
+func within(dir, p string) bool {
+	rel, err := filepath.Rel(dir, p)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// removeEmptyParents 从 dir 开始向上删除空目录，遇到非空目录或 stop 返回 true 的目录时停止
+func removeEmptyParents(dir string, stop func(dir string) bool) {
+	for ; !stop(dir); dir = filepath.Dir(dir) {
+		if entries, err := os.ReadDir(dir); err != nil || len(entries) > 0 {
+			return
+		}
+		if err := os.Remove(dir); err != nil {
+			return
+		}
+		logrus.Infof("[Clean] %s", dir)
+		if filepath.Dir(dir) == dir {
+			return
+		}
+	}
+}
+
+// purgeTrash 删除回收站中超过保留时间的批次
+func purgeTrash(trash string, retention time.Duration) {
+	entries, err := os.ReadDir(trash)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		t, err := time.ParseInLocation(trashLayout, entry.Name(), time.Local)
+		if err != nil || !entry.IsDir() || time.Since(t) < retention {
+			continue
+		}
+		if err = os.RemoveAll(filepath.Join(trash, entry.Name())); err != nil {
+			logrus.Errorf("[Clean] purge trash %s error: %v", entry.Name(), err)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package job
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestCleanOwnedOnly(t *testing.T) {
+	setDataDir(t)
+	dest := t.TempDir()
+	root := filepath.Join(dest, "movies")
+	file := func(name string) string {
+		p := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(name), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	keep := file("A/A.strm")
+	gone := file("B/B.strm")
+	extra := file("B/B.jpg")
+	season := file("D/Season 1/D.strm")
+	// 用户自己创建的空目录
+	empty := filepath.Join(root, "E")
+	if err := os.MkdirAll(empty, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	// Emby 和刮削工具生成的文件，扩展名与任务生成的相同
+	scraped := []string{file("B/movie.nfo"), file("B/poster.jpg"), file("B/B-thumb.jpg"), file("C/C.strm")}
+	opts := &Opts{Extra: `(?i)\.(jpg|nfo)$`, Organize: true, CleanLimit: 1}
+
+	// 第一次运行写入文件并记录清单
+	c, err := OpenCleaner("job")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Root(root)
+	for _, p := range []string{keep, gone, extra, season} {
+		c.Keep(p)
+		c.Wrote(p)
+	}
+	if removed, err := c.Clean(opts, dest, false); err != nil || len(removed) != 0 {
+		t.Fatalf("first Clean() = %v, %v, want nothing removed", removed, err)
+	}
+
+	// 第二次运行 B 已经不在源端
+	c, err = OpenCleaner("job")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Root(root)
+	c.Keep(keep)
+	plan, err := c.Clean(opts, dest, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{extra, gone, season}
+	if !reflect.DeepEqual(plan, want) {
+		t.Errorf("dry run Clean() = %v, want %v", plan, want)
+	}
+	removed, err := c.Clean(opts, dest, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(removed, want) {
+		t.Errorf("Clean() = %v, want %v", removed, want)
+	}
+	for _, p := range append(scraped, keep, empty, root) {
+		if _, err := os.Stat(p); err != nil {
+			t.Errorf("%s should not be removed: %v", p, err)
+		}
+	}
+	// 清理后变空的目录逐级删除
+	if _, err := os.Stat(filepath.Join(root, "D")); !os.IsNotExist(err) {
+		t.Errorf("empty directory D is not removed: %v", err)
+	}
+
+	// 清单中只剩下仍然存在的文件
+	c, err = OpenCleaner("job")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.owned[keep]; !ok || len(c.owned) != 1 {
+		t.Errorf("manifest = %v, want only %s", c.owned, keep)
+	}
+}
@@ -0,0 +1,113 @@
+package job
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// PathFilter 按 include/exclude 规则和文件大小过滤源端的路径
+//
+// 规则以 re: 开头时为正则表达式，匹配完整路径的任意部分；否则为通配符，
+// * 和 ? 不匹配 /，** 匹配任意层级，不含 / 的通配符只匹配最后一级名称
+type PathFilter struct {
+	include []*regexp.Regexp
+	exclude []*regexp.Regexp
+	minSize int64
+	maxSize int64
+}
+
+// PathFilter 编译任务的过滤规则
+func (o *Opts) PathFilter() (f *PathFilter, err error) {
+	f = &PathFilter{minSize: int64(o.MinSize * 1024 * 1024), maxSize: int64(o.MaxSize * 1024 * 1024)}
+	if f.include, err = compilePatterns(o.Include); err != nil {
+		return
+	}
+	f.exclude, err = compilePatterns(o.Exclude)
+	return
+}
+
+// Dir 判断是否需要进入目录，被排除的目录不会被遍历
+func (f *PathFilter) Dir(p string) bool {
+	return !matchAny(f.exclude, p)
+}
+
+// File 判断是否需要处理文件，media 为 false（extra 文件）时不检查 include 和大小
+func (f *PathFilter) File(p string, size int64, media bool) bool {
+	if matchAny(f.exclude, p) {
+		return false
+	}
+	if !media {
+		return true
+	}
+	if len(f.include) > 0 && !matchAny(f.include, p) {
+		return false
+	}
+	// 大小为 0 表示源端没有提供精确大小（如 http 目录索引的 1.5M），不按最小大小过滤
+	if f.minSize > 0 && size > 0 && size < f.minSize {
+		return false
+	}
+	if f.maxSize > 0 && size > f.maxSize {
+		return false
+	}
+	return true
+}
+
+func matchAny(patterns []*regexp.Regexp, p string) bool {
+	for _, re := range patterns {
+		if re.MatchString(p) {
+			return true
+		}
+	}
+	return false
+}
+
+func compilePatterns(patterns []string) (res []*regexp.Regexp, err error) {
+	for _, pattern := range patterns {
+		var expr string
+		if strings.HasPrefix(pattern, "re:") {
+			expr = strings.TrimPrefix(pattern, "re:")
+		} else {
+			expr = globToRegexp(pattern)
+		}
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("pattern %q: %w", pattern, err)
+		}
+		res = append(res, re)
+	}
+	return
+}
+
+func globToRegexp(glob string) string {
+	var b strings.Builder
+	if strings.Contains(glob, "/") {
+		// 含路径的通配符匹配完整路径
+		b.WriteString("^")
+		if !strings.HasPrefix(glob, "/") {
+			b.WriteString("(?:.*/)?")
+		}
+	} else {
+		// 只匹配最后一级名称
+		b.WriteString("(?:^|/)")
+	}
+	runes := []rune(path.Clean(glob))
+	for i := 0; i < len(runes); i++ {
+		switch c := runes[i]; c {
+		case '*':
+			if i+1 < len(runes) && runes[i+1] == '*' {
+				b.WriteString(".*")
+				i++
+			} else {
+				b.WriteString("[^/]*")
+			}
+		case '?':
+			b.WriteString("[^/]")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+	return b.String()
+}
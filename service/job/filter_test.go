@@ -0,0 +1,127 @@
+package job
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestGlobToRegexp(t *testing.T) {
+	tests := []struct {
+		glob string
+		path string
+		want bool
+	}{
+		// 不含 / 的通配符只匹配最后一级名称
+		{"Sample", "/movie/Sample", true},
+		{"Sample", "/movie/Sample/a.mkv", false},
+		{"Sample", "/movie/Samples", false},
+		{"*.iso", "/movie/a.iso", true},
+		{"*.iso", "/movie/a.iso/b.mkv", false},
+		{"a?.mkv", "/movie/ab.mkv", true},
+		{"a?.mkv", "/movie/a/.mkv", false},
+		{"*花絮*", "/tv/Show/花絮 01.mkv", true},
+		{"*花絮*", "/tv/花絮/a.mkv", false},
+		// 含 / 但不以 / 开头时可以从任意一级目录开始匹配
+		{"Show/*.mkv", "/tv/Show/a.mkv", true},
+		{"Show/*.mkv", "/tv/Show/Season 1/a.mkv", false},
+		{"Show/*.mkv", "/tv/MyShow/a.mkv", false},
+		// 以 / 开头时从根目录开始匹配
+		{"/tv/Show", "/tv/Show", true},
+		{"/tv/Show", "/media/tv/Show", false},
+		{"/tv/*", "/tv/Show", true},
+		{"/tv/*", "/tv/Show/a.mkv", false},
+		// ** 匹配任意层级
+		{"/tv/**/Extras", "/tv/Show/Season 1/Extras", true},
+		{"/tv/**/Extras", "/movie/Show/Extras", false},
+		{"**/Extras/**", "/tv/Show/Extras/a.mkv", true},
+		{"/tv/**", "/tv/Show/Season 1/a.mkv", true},
+		// 正则中的特殊字符按原样匹配
+		{"[SP].mkv", "/movie/[SP].mkv", true},
+		{"[SP].mkv", "/movie/S.mkv", false},
+		{"a+b (2020).mkv", "/movie/a+b (2020).mkv", true},
+	}
+	for _, tt := range tests {
+		re := regexp.MustCompile(globToRegexp(tt.glob))
+		if got := re.MatchString(tt.path); got != tt.want {
+			t.Errorf("glob %q (%s) match %q = %v, want %v", tt.glob, re, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestPathFilter(t *testing.T) {
+	// README 中的示例
+	f, err := (&Opts{
+		Exclude: []string{"Sample", "*花絮*", "re:(?i)trailer", "/tv/**/Extras"},
+		MinSize: 50,
+		MaxSize: 0.5 * 1024,
+	}).PathFilter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	const mb = 1024 * 1024
+	files := []struct {
+		path  string
+		size  int64
+		media bool
+		want  bool
+	}{
+		{"/movie/A (2020)/A.mkv", 100 * mb, true, true},
+		{"/movie/A (2020)/A-花絮.mkv", 100 * mb, true, false},
+		{"/movie/A (2020)/A.Trailer.mkv", 100 * mb, true, false},
+		{"/movie/Trailers/A.mkv", 100 * mb, true, false},
+		{"/movie/A (2020)/Sample", 100 * mb, true, false},
+		{"/movie/A (2020)/Sample.mkv", 100 * mb, true, true},
+		{"/tv/Show/Season 1/Extras/a.mkv", 100 * mb, true, true}, // 只排除目录本身，由 Dir 跳过
+		// 大小以 MB 计算，只限制视频文件
+		{"/movie/A (2020)/A.mkv", 50 * mb, true, true},
+		{"/movie/A (2020)/A.mkv", 50*mb - 1, true, false},
+		{"/movie/A (2020)/A.mkv", 512 * mb, true, true},
+		{"/movie/A (2020)/A.mkv", 512*mb + 1, true, false},
+		{"/movie/A (2020)/A.mkv", 0, true, true}, // 大小未知
+		{"/movie/A (2020)/A.srt", 1024, false, true},
+		{"/movie/A (2020)/A-花絮.srt", 1024, false, false},
+	}
+	for _, tt := range files {
+		if got := f.File(tt.path, tt.size, tt.media); got != tt.want {
+			t.Errorf("File(%q, %d, %v) = %v, want %v", tt.path, tt.size, tt.media, got, tt.want)
+		}
+	}
+	dirs := []struct {
+		path string
+		want bool
+	}{
+		{"/movie/A (2020)", true},
+		{"/movie/A (2020)/Sample", false},
+		{"/tv/Show/花絮", false},
+		{"/tv/Show/Season 1/Extras", false},
+		{"/movie/Extras", true},
+	}
+	for _, tt := range dirs {
+		if got := f.Dir(tt.path); got != tt.want {
+			t.Errorf("Dir(%q) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+
+	// include 只限制视频文件
+	f, err = (&Opts{Include: []string{"/movie/**"}}).PathFilter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		path  string
+		media bool
+		want  bool
+	}{
+		{"/movie/A (2020)/A.mkv", true, true},
+		{"/tv/Show/S01E01.mkv", true, false},
+		{"/tv/Show/S01E01.srt", false, true},
+	} {
+		if got := f.File(tt.path, 0, tt.media); got != tt.want {
+			t.Errorf("include File(%q, %v) = %v, want %v", tt.path, tt.media, got, tt.want)
+		}
+	}
+
+	if _, err = (&Opts{Exclude: []string{"re:("}}).PathFilter(); err == nil {
+		t.Error("PathFilter() with an invalid regexp should return an error")
+	}
+}
@@ -0,0 +1,97 @@
+package job
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// 任务的触发方式
+const (
+	TriggerCron   = "cron"
+	TriggerManual = "manual"
+)
+
+// HistoryKeep 每个任务保留的运行记录条数，由 server 在启动时设置
+var HistoryKeep = 50
+
+var historyMu sync.Mutex
+
+// Record 任务的一次运行记录
+type Record struct {
+	Id           string    `json:"id"`
+	Trigger      string    `json:"trigger"`
+	Start        time.Time `json:"start"`
+	End          time.Time `json:"end"`
+	Status       string    `json:"status"`
+	Error        string    `json:"error,omitempty"`
+	Stats        Stats     `json:"stats"`
+	ErrorSamples []string  `json:"errorSamples,omitempty"`
+	Backends     []string  `json:"backends,omitempty"` // 本次运行使用的源端服务器
+}
+
+func historyPath(key string) string {
+	return filepath.Join(DataDir, "runs", key+".json")
+}
+
+// Runs 返回任务的运行记录，最新的在前
+func Runs(key string) (records []*Record, err error) {
+	historyMu.Lock()
+	defer historyMu.Unlock()
+	return loadRuns(key)
+}
+
+// FindRun 查找任务的某一次运行记录
+func FindRun(key, id string) (*Record, error) {
+	records, err := Runs(key)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range records {
+		if r.Id == id {
+			return r, nil
+		}
+	}
+	return nil, nil
+}
+
+func loadRuns(key string) (records []*Record, err error) {
+	var bytes []byte
+	if bytes, err = os.ReadFile(historyPath(key)); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			err = nil
+		}
+		return
+	}
+	err = json.Unmarshal(bytes, &records)
+	return
+}
+
+// saveRun 追加一条运行记录，超出保留条数的旧记录会被删除
+func saveRun(key string, r *Record) (err error) {
+	historyMu.Lock()
+	defer historyMu.Unlock()
+
+	records, _ := loadRuns(key)
+	records = append([]*Record{r}, records...)
+	if HistoryKeep > 0 && len(records) > HistoryKeep {
+		records = records[:HistoryKeep]
+	}
+
+	var bytes []byte
+	if bytes, err = json.Marshal(records); err != nil {
+		return
+	}
+	p := historyPath(key)
+	if err = os.MkdirAll(filepath.Dir(p), os.ModePerm); err != nil {
+		return
+	}
+	tmp := p + ".tmp"
+	if err = os.WriteFile(tmp, bytes, 0644); err != nil {
+		return
+	}
+	return os.Rename(tmp, p)
+}
@@ -0,0 +1,28 @@
+package job
+
+import (
+	"sync"
+)
+
+// Hook 在任务运行结束并保存运行记录后调用，试运行不会触发
+type Hook func(s *Session, r *Record)
+
+var (
+	hooksMu sync.RWMutex
+	hooks   []Hook
+)
+
+// AddHook 注册任务运行结束后的回调
+func AddHook(h Hook) {
+	hooksMu.Lock()
+	defer hooksMu.Unlock()
+	hooks = append(hooks, h)
+}
+
+func runHooks(s *Session, r *Record) {
+	hooksMu.RLock()
+	defer hooksMu.RUnlock()
+	for _, h := range hooks {
+		h(s, r)
+	}
+}
@@ -0,0 +1,220 @@
+package job
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+)
+
+// DataDir 任务运行数据（索引等）的存放目录，由 server 在启动时设置
+var DataDir = "data"
+
+// IndexEntry 索引中记录的单个源端文件
+type IndexEntry struct {
+	Size     int64  `json:"size"`
+	Modified string `json:"modified"`
+	Hash     string `json:"hash,omitempty"`
+	Content  string `json:"content,omitempty"` // 生成的 strm 内容，extra 文件为空
+	Path     string `json:"path"`              // 本地保存路径
+}
+
+// Same 判断源端文件的元数据是否与索引一致
+func (e *IndexEntry) Same(size int64, modified, hash string) bool {
+	return e != nil && e.Size == size && e.Modified == modified && e.Hash == hash
+}
+
+// IndexDir 索引中记录的源端目录
+type IndexDir struct {
+	Modified string   `json:"modified"`       // 修改时间，未知时为空
+	Dirs     []string `json:"dirs,omitempty"` // 需要遍历的子目录
+}
+
+type indexData struct {
+	Config string                 `json:"config"` // 影响输出的任务配置的哈希
+	Files  map[string]*IndexEntry `json:"files"`  // 源端文件路径 -> 文件信息
+	Dirs   map[string]*IndexDir   `json:"dirs"`   // 源端目录路径 -> 目录信息
+}
+
+func newIndexData() indexData {
+	return indexData{Files: map[string]*IndexEntry{}, Dirs: map[string]*IndexDir{}}
+}
+
+// Index 任务的增量同步索引
+//
+// prev 为上一次运行保存的索引，cur 为本次运行中重新记录的索引。
+// 目录的修改时间只在直接子项增删时变化，因此只跳过未变化的目录本身，
+// 其中的文件在 Carry 时从 prev 继承，子目录仍然继续遍历
+type Index struct {
+	mu     sync.Mutex
+	path   string
+	prev   indexData
+	cur    indexData
+	pruned map[string]struct{}
+	failed bool
+}
+
+func indexPath(key string) string {
+	return filepath.Join(DataDir, "index", key+".json")
+}
+
+// OpenIndex 加载任务的索引，不存在或损坏时返回空索引
+func OpenIndex(key string) (x *Index, err error) {
+	x = &Index{path: indexPath(key), prev: newIndexData(), cur: newIndexData(), pruned: map[string]struct{}{}}
+	var bytes []byte
+	if bytes, err = os.ReadFile(x.path); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			err = nil
+		}
+		return
+	}
+	if err = json.Unmarshal(bytes, &x.prev); err != nil {
+		x.prev = newIndexData()
+		return
+	}
+	if x.prev.Files == nil {
+		x.prev.Files = map[string]*IndexEntry{}
+	}
+	if x.prev.Dirs == nil {
+		x.prev.Dirs = map[string]*IndexDir{}
+	}
+	return
+}
+
+// Config 设置影响输出的任务配置的哈希，与上一次运行不同时丢弃上一次的索引，
+// 避免 dest、路径重写、过滤等修改后继续使用旧的保存路径和内容
+func (x *Index) Config(hash string) {
+	if x == nil {
+		return
+	}
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if x.prev.Config != hash {
+		x.prev = newIndexData()
+	}
+	x.cur.Config = hash
+}
+
+// ResetIndex 删除任务的索引，下一次运行将全量同步
+func ResetIndex(key string) (err error) {
+	if err = os.Remove(indexPath(key)); errors.Is(err, os.ErrNotExist) {
+		err = nil
+	}
+	return
+}
+
+// Prune 判断目录自上次运行以来是否未发生变化，未变化的目录不再列出，
+// 返回上次记录的子目录，子目录的修改时间未知，需要继续列出
+func (x *Index) Prune(dir, modified string) (dirs []string, ok bool) {
+	if x == nil || modified == "" {
+		return
+	}
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	last, ok := x.prev.Dirs[dir]
+	if !ok || last.Modified != modified {
+		return nil, false
+	}
+	x.pruned[dir] = struct{}{}
+	x.cur.Dirs[dir] = last
+	return last.Dirs, true
+}
+
+// Listed 记录列出的目录及需要遍历的子目录
+func (x *Index) Listed(dir, modified string, dirs []string) {
+	if x == nil {
+		return
+	}
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.cur.Dirs[dir] = &IndexDir{Modified: modified, Dirs: dirs}
+}
+
+// Lookup 查找上一次运行记录的文件
+func (x *Index) Lookup(name string) *IndexEntry {
+	if x == nil {
+		return nil
+	}
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	return x.prev.Files[name]
+}
+
+// Put 记录本次运行已经同步到本地的文件
+func (x *Index) Put(name string, e *IndexEntry) {
+	if x == nil {
+		return
+	}
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.cur.Files[name] = e
+}
+
+// Fail 标记本次遍历不完整，此时不会保存目录修改时间，避免下次错误地剪枝
+func (x *Index) Fail() {
+	if x == nil {
+		return
+	}
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.failed = true
+}
+
+// Carry 继承被剪枝目录中文件的记录，keep 会收到所有继承的文件
+func (x *Index) Carry(keep func(name string, e *IndexEntry)) {
+	if x == nil {
+		return
+	}
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	if len(x.pruned) == 0 {
+		return
+	}
+	for name, e := range x.prev.Files {
+		if _, ok := x.pruned[path.Dir(name)]; !ok {
+			continue
+		}
+		if _, ok := x.cur.Files[name]; !ok {
+			x.cur.Files[name] = e
+			if keep != nil {
+				keep(name, e)
+			}
+		}
+	}
+	x.pruned = map[string]struct{}{}
+}
+
+// Save 保存本次运行的索引
+func (x *Index) Save() (err error) {
+	if x == nil {
+		return
+	}
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	if x.failed {
+		// 遍历不完整时保留上次的文件记录，并丢弃目录记录
+		for name, e := range x.prev.Files {
+			if _, ok := x.cur.Files[name]; !ok {
+				x.cur.Files[name] = e
+			}
+		}
+		x.cur.Dirs = map[string]*IndexDir{}
+	}
+
+	var bytes []byte
+	if bytes, err = json.Marshal(x.cur); err != nil {
+		return
+	}
+	if err = os.MkdirAll(filepath.Dir(x.path), os.ModePerm); err != nil {
+		return
+	}
+	tmp := x.path + ".tmp"
+	if err = os.WriteFile(tmp, bytes, 0644); err != nil {
+		return
+	}
+	return os.Rename(tmp, x.path)
+}
@@ -1,23 +1,47 @@
 package job
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"io"
 	"os"
+	"path"
 	"path/filepath"
+	"slices"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/sirupsen/logrus"
 )
 
 type Opts struct {
-	Deep      int              `yaml:"deep" json:"deep"`
-	Overwrite bool             `yaml:"overwrite" json:"overwrite"`
-	Filters   string           `yaml:"filters" json:"filters"`
-	Refresh   bool             `yaml:"refresh" json:"refresh"`
-	Extra     string           `yaml:"extra" json:"extra"`
-	Interval  float64          `yaml:"interval" json:"interval"`
-	C         <-chan time.Time `yaml:"-" json:"-"`
+	Deep       int              `yaml:"deep" json:"deep"`
+	Overwrite  bool             `yaml:"overwrite" json:"overwrite"`
+	Filters    string           `yaml:"filters" json:"filters"`
+	Refresh    bool             `yaml:"refresh" json:"refresh"`
+	Extra      string           `yaml:"extra" json:"extra"`
+	Interval   float64          `yaml:"interval" json:"interval"`
+	Clean      bool             `yaml:"clean" json:"clean"`           // 同步模式，删除源端已不存在的本地文件
+	Trash      string           `yaml:"trash" json:"trash"`           // 回收站目录，为空则直接删除
+	TrashDays  int              `yaml:"trashDays" json:"trashDays"`   // 回收站保留天数，0 表示永久保留
+	CleanLimit float64          `yaml:"cleanLimit" json:"cleanLimit"` // 单次清理的最大比例，超过则放弃清理，默认 0.5
+	Index      bool             `yaml:"index" json:"index"`           // 增量同步，跳过索引中未变化的文件和目录
+	Rewrite    []Rewrite        `yaml:"rewrite" json:"rewrite"`       // 按顺序应用的路径重写规则
+	Include    []string         `yaml:"include" json:"include"`       // 只处理匹配的视频文件，通配符或 re: 开头的正则，匹配 alist 完整路径
+	Exclude    []string         `yaml:"exclude" json:"exclude"`       // 跳过匹配的目录和文件，被排除的目录不会被遍历
+	MinSize    float64          `yaml:"minSize" json:"minSize"`       // 视频文件的最小大小（MB），0 表示不限制
+	MaxSize    float64          `yaml:"maxSize" json:"maxSize"`       // 视频文件的最大大小（MB），0 表示不限制
+	ExtraRate  float64          `yaml:"extraRate" json:"extraRate"`   // extra 文件的总下载速度上限（KB/s），0 表示不限制
+	EmbyNotify string           `yaml:"embyNotify" json:"embyNotify"` // 运行成功且有文件变化后通知 Emby: updated 只扫描变化的目录, refresh 扫描全部媒体库
+	EmbyPath   string           `yaml:"embyPath" json:"embyPath"`     // Dest 在 Emby 中对应的路径，为空表示与 Dest 相同
+	Organize   bool             `yaml:"organize" json:"organize"`     // 按文件名解析出的剧名、季整理目录，并生成 nfo 文件
+	Parallel   int              `yaml:"parallel" json:"parallel"`     // 同时列出的源端目录数，默认 1
+	MaxDepth   int              `yaml:"maxDepth" json:"maxDepth"`     // 最多遍历 From 下几层子目录，0 表示不限制
+	C          <-chan time.Time `yaml:"-" json:"-"`
 }
 
 type SaveOpt struct {
@@ -27,14 +51,34 @@ type SaveOpt struct {
 	Name       string
 	Body       io.Reader
 	ModifyTime time.Time
+	Source     string                        // 源端文件路径
+	IsExtra    bool                          // 是否为 extra 文件
+	Size       int64                         // 源端文件大小，大于 0 时校验写入的大小
+	Hash       map[string]string             // 源端文件哈希，支持 md5、sha1、sha256，写入时校验
+	Open       func() (io.ReadCloser, error) // 按需打开内容，设置后忽略 Body
+	Ctx        context.Context               // 用于取消重试等待
 }
 
 func (opt *SaveOpt) FmtSavePath() string {
 	fromDirs := strings.Split(strings.TrimLeft(opt.From, "/"), "/")
 	opt.Dest = strings.ReplaceAll(opt.Dest, "/", string(filepath.Separator))
-	targetDir := filepath.Join(append([]string{opt.Dest}, fromDirs[len(fromDirs)-opt.Deep:]...)...)
+	name := strings.Replace(opt.Name, opt.From, "", -1)
+	if opt.Organize {
+		name, _, _ = organize(name)
+	}
+	// deep 为 0 时 name 以 / 开头，去掉后重写规则总是匹配不以 / 开头的相对路径
+	rel := strings.TrimPrefix(path.Join(append(fromDirs[len(fromDirs)-opt.Deep:], name)...), "/")
+	rel = rewritePath(opt.Rewrite, rel)
 
-	return filepath.Join(targetDir, strings.Replace(opt.Name, opt.From, "", -1))
+	return filepath.Join(opt.Dest, filepath.FromSlash(rel))
+}
+
+// RootDir 本地保存 From 下文件的根目录，配置了路径重写时文件可能被移动到 Dest 下的任意位置
+func (opt *SaveOpt) RootDir() string {
+	if len(opt.Rewrite) > 0 {
+		return filepath.Clean(strings.ReplaceAll(opt.Dest, "/", string(filepath.Separator)))
+	}
+	return opt.FmtSavePath()
 }
 
 func (opt *SaveOpt) IsWrite(savePath string, referenceTime time.Time) (state bool) {
@@ -63,69 +107,366 @@ func (opt *SaveOpt) IsWrite(savePath string, referenceTime time.Time) (state boo
 }
 
 type Job struct {
-	Id          string  `yaml:"-" json:"id,omitempty"`
-	Name        string  `yaml:"name" json:"name,omitempty"`
-	Alist       int     `yaml:"alist" json:"alist"`
-	From        string  `yaml:"from" json:"from,omitempty"`
-	Dest        string  `yaml:"dest" json:"dest,omitempty"`
-	Mode        string  `yaml:"mode" json:"mode,omitempty"`
-	Spec        string  `yaml:"spec" json:"spec"`
-	Opts        *Opts   `yaml:"opts" json:"opts"`
-	Handler     Handler `yaml:"-" json:"-"`
-	Concurrency int     `yaml:"concurrency" json:"concurrency"`
-	Status      string  `yaml:"-" json:"status,omitempty"`      // 运行状态: idle, running, success, failed
-	LastRunTime string  `yaml:"-" json:"lastRunTime,omitempty"` // 最后运行时间
-	LastError   string  `yaml:"-" json:"lastError,omitempty"`   // 最后错误信息
-}
-
-func (j *Job) Run() {
+	Id          string   `yaml:"-" json:"id,omitempty"`
+	Key         string   `yaml:"key" json:"key,omitempty"` // 任务的持久化标识，用于关联索引等运行数据
+	Name        string   `yaml:"name" json:"name,omitempty"`
+	Source      string   `yaml:"source" json:"source,omitempty"` // 源端类型: alist（默认）, local, webdav, http
+	Alist       int      `yaml:"alist" json:"alist"`
+	Failover    []int    `yaml:"failover" json:"failover,omitempty"` // 备用 alist 序号，Alist 不可用时按顺序切换
+	Server      int      `yaml:"server" json:"server"`               // 源端为 webdav/http 时使用的服务器序号
+	From        string   `yaml:"from" json:"from,omitempty"`
+	Dest        string   `yaml:"dest" json:"dest,omitempty"`
+	Mode        string   `yaml:"mode" json:"mode,omitempty"`
+	Template    string   `yaml:"template" json:"template,omitempty"` // strm 内容模板，配置后忽略 Mode
+	PathMap     string   `yaml:"pathMap" json:"pathMap,omitempty"`   // 非 alist 源端的路径映射，格式为 "源端前缀 -> 目标前缀"
+	Spec        string   `yaml:"spec" json:"spec"`
+	Opts        *Opts    `yaml:"opts" json:"opts"`
+	Handler     Handler  `yaml:"-" json:"-"`
+	Concurrency int      `yaml:"concurrency" json:"concurrency"`
+	Overlap     string   `yaml:"overlap" json:"overlap,omitempty"` // 重叠运行策略: skip, queue, replace
+	Status      string   `yaml:"-" json:"status,omitempty"`        // 运行状态: idle, running, success, failed, cancelled
+	LastRunTime string   `yaml:"-" json:"lastRunTime,omitempty"`   // 最后运行时间
+	LastError   string   `yaml:"-" json:"lastError,omitempty"`     // 最后错误信息
+	LastRemoved []string `yaml:"-" json:"lastRemoved,omitempty"`   // 最后一次同步清理掉的文件
+
+	mu       sync.Mutex
+	cancel   context.CancelFunc
+	running  bool
+	pending  string   // 排队等待运行的触发方式
+	session  *Session // 正在运行的会话
+	lastPlan *Plan    // 最后一次试运行的计划
+}
+
+// start 运行任务并保存运行记录，由 Trigger 保证同一任务不会并发运行，ctx 由调用方持有锁时创建
+func (j *Job) start(ctx context.Context, trigger string) {
+	s := newSession(ctx, j, false)
+	j.mu.Lock()
+	j.session = s
 	// 设置为运行中
 	j.Status = "running"
-	j.LastRunTime = time.Now().Format("2006-01-02 15:04:05")
+	j.LastRunTime = s.Start.Format("2006-01-02 15:04:05")
 	j.LastError = ""
+	j.LastRemoved = nil
+	j.mu.Unlock()
+	defer func() {
+		j.mu.Lock()
+		j.session = nil
+		j.mu.Unlock()
+	}()
 
 	logrus.Printf("[start] job name: %s, job id: %s\n", j.Name, j.Id)
-	err := j.Handler.Handle(j)
-	if err != nil {
+	err := j.Handler.Handle(s)
+	var status, lastError string
+	if ctx.Err() != nil {
+		// 设置为已取消
+		status, lastError = "cancelled", ctx.Err().Error()
+		logrus.Printf("[cancelled] job name: %s, job id: %s\n", j.Name, j.Id)
+	} else if err != nil {
 		// 设置为失败
-		j.Status = "failed"
-		j.LastError = err.Error()
+		status, lastError = "failed", err.Error()
 		logrus.Printf("[failed] job name: %s, job id: %s, err: %v\n", j.Name, j.Id, err)
-		return
+	} else {
+		// 设置为成功
+		status = "success"
+		logrus.Printf("[success] job name: %s, job id: %s\n", j.Name, j.Id)
+	}
+	j.mu.Lock()
+	j.Status, j.LastError = status, lastError
+	j.mu.Unlock()
+
+	s.mu.Lock()
+	record := &Record{
+		Id:           s.Id,
+		Trigger:      trigger,
+		Start:        s.Start,
+		End:          time.Now(),
+		Status:       status,
+		Error:        lastError,
+		Stats:        s.stats,
+		ErrorSamples: s.samples,
+		Backends:     s.backends,
+	}
+	s.mu.Unlock()
+	if err = saveRun(j.Key, record); err != nil {
+		logrus.Errorf("[history] job name: %s, save run record error: %v", j.Name, err)
+	}
+	runHooks(s, record)
+}
+
+// MarshalJSON 持有锁编码任务，运行状态等字段在运行中会被修改
+func (j *Job) MarshalJSON() ([]byte, error) {
+	type plain Job
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return json.Marshal((*plain)(j))
+}
+
+// indexConfig 返回影响保存路径和 strm 内容的配置的哈希，变化后增量索引失效
+func (j *Job) indexConfig() string {
+	o := j.Opts
+	data, _ := json.Marshal([]any{
+		j.Source, j.Alist, j.Server, j.From, j.Dest, j.Mode, j.Template, j.PathMap,
+		o.Deep, o.Filters, o.Extra, o.Rewrite, o.Include, o.Exclude, o.MinSize, o.MaxSize, o.Organize, o.MaxDepth,
+	})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Config 持有锁返回任务配置字段的副本，Opts 及其中的切片也会复制，
+// 修改接口将请求解码到副本上，未提交的字段保留原值
+func (j *Job) Config() *Job {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	c := &Job{
+		Id:          j.Id,
+		Key:         j.Key,
+		Name:        j.Name,
+		Source:      j.Source,
+		Alist:       j.Alist,
+		Failover:    slices.Clone(j.Failover),
+		Server:      j.Server,
+		From:        j.From,
+		Dest:        j.Dest,
+		Mode:        j.Mode,
+		Template:    j.Template,
+		PathMap:     j.PathMap,
+		Spec:        j.Spec,
+		Handler:     j.Handler,
+		Concurrency: j.Concurrency,
+		Overlap:     j.Overlap,
+	}
+	if j.Opts != nil {
+		o := *j.Opts
+		o.Rewrite = slices.Clone(o.Rewrite)
+		o.Include = slices.Clone(o.Include)
+		o.Exclude = slices.Clone(o.Exclude)
+		c.Opts = &o
+	}
+	return c
+}
+
+// Update 持有锁复制 src 中的配置字段，Key 和运行状态保持不变，
+// 任务运行或试运行中时返回 ErrRunning，避免修改正在遍历使用的配置
+func (j *Job) Update(src *Job) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.running {
+		return ErrRunning
+	}
+	j.Name = src.Name
+	j.Source = src.Source
+	j.Alist = src.Alist
+	j.Failover = src.Failover
+	j.Server = src.Server
+	j.From = src.From
+	j.Dest = src.Dest
+	j.Mode = src.Mode
+	j.Template = src.Template
+	j.PathMap = src.PathMap
+	j.Spec = src.Spec
+	if src.Opts != nil {
+		j.Opts = src.Opts
+	}
+	j.Handler = src.Handler
+	j.Concurrency = src.Concurrency
+	j.Overlap = src.Overlap
+	return nil
+}
+
+// Validate 检查任务配置，用于创建和修改任务时提前发现错误
+func (j *Job) Validate() error {
+	if j.Opts != nil {
+		for _, r := range j.Opts.Rewrite {
+			if _, err := r.compile(); err != nil {
+				return err
+			}
+		}
+		if _, err := j.Opts.PathFilter(); err != nil {
+			return err
+		}
 	}
+	tpl, err := j.StrmTemplate()
+	if tpl == nil || err != nil {
+		return err
+	}
+	// 使用示例数据执行一次模板，检查字段名等错误
+	sample := NewStrmData("http://alist", "/path/to/file.mkv", "sign", 1, time.Now(), "http://alist/d/path/to/file.mkv",
+		func() (string, error) { return "http://raw", nil })
+	return tpl.Execute(io.Discard, sample)
+}
+
+// Plan 在后台试运行任务，返回正在生成的计划，计划在结束前会随遍历逐步更新
+//
+// 试运行与正式运行共用重叠锁：任务运行中时返回 ErrRunning，
+// 试运行期间的触发按 Overlap 策略处理，Cancel 可以取消试运行
+func (j *Job) Plan() (*Plan, error) {
+	j.mu.Lock()
+	if j.running {
+		j.mu.Unlock()
+		return nil, ErrRunning
+	}
+	j.running = true
+	ctx, cancel := context.WithCancel(context.Background())
+	j.cancel = cancel
+	j.mu.Unlock()
+
+	s := newSession(ctx, j, true)
+	j.mu.Lock()
+	j.lastPlan = s.Plan
+	j.mu.Unlock()
+
+	go func() {
+		defer cancel()
+		s.Plan.finish(j.Handler.Handle(s))
+
+		j.mu.Lock()
+		defer j.mu.Unlock()
+		j.cancel = nil
+		if j.pending == "" {
+			j.running = false
+			return
+		}
+		// 试运行期间排队的触发
+		trigger := j.pending
+		j.pending = ""
+		j.goLoop(trigger)
+	}()
+	return s.Plan, nil
+}
 
-	// 设置为成功
-	j.Status = "success"
-	logrus.Printf("[success] job name: %s, job id: %s\n", j.Name, j.Id)
+// LastPlan 返回最后一次试运行的计划，没有试运行过时返回 nil
+func (j *Job) LastPlan() *Plan {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.lastPlan
 }
 
-func Save(opt SaveOpt) (err error) {
+// Save 将 Body 写入本地文件，written 表示文件是否被写入
+//
+// 先写入同目录下的临时文件再重命名，中断时不会留下写了一半的文件；
+// strm 内容与本地文件完全相同时不重写，文件的修改时间设置为源端的 ModifyTime。
+// 设置了 Open 时只在需要写入时才打开内容，打开、写入或校验失败会按退避间隔重试
+func Save(opt SaveOpt) (written bool, err error) {
 	filePath := opt.FmtSavePath()
 	if !opt.IsWrite(filePath, opt.ModifyTime) {
 		return
 	}
-	var file *os.File
+	if opt.IsExtra && opt.Same(filePath) {
+		// 大小和哈希都没有变化，不需要重新下载
+		setModTime(filePath, opt.ModifyTime)
+		return
+	}
+
+	if opt.Open == nil {
+		return writeFile(&opt, filePath, opt.Body)
+	}
+
+	ctx := opt.Ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	for attempt := 0; ; attempt++ {
+		var body io.ReadCloser
+		if body, err = opt.Open(); err == nil {
+			written, err = writeFile(&opt, filePath, body)
+			_ = body.Close()
+		}
+		if err == nil || attempt >= SaveRetries || ctx.Err() != nil {
+			return
+		}
+
+		backoff := saveBackoff << attempt
+		logrus.Warningf("[Save] %s error: %v, retry in %s", opt.Source, err, backoff)
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return false, ctx.Err()
+		}
+	}
+}
+
+// SaveRetries 打开或写入失败后的重试次数
+const SaveRetries = 3
+
+// saveBackoff 第一次重试前的等待时间，之后每次加倍
+var saveBackoff = time.Second
+
+func writeFile(opt *SaveOpt, filePath string, body io.Reader) (written bool, err error) {
+	if !opt.IsExtra {
+		var content []byte
+		if content, err = io.ReadAll(body); err != nil {
+			return
+		}
+		if sameContent(filePath, content) {
+			// 内容相同只修正修改时间，避免再次比较
+			setModTime(filePath, opt.ModifyTime)
+			return
+		}
+		body = bytes.NewReader(content)
+	}
+
 	dirName := filepath.Dir(filePath)
 	err = os.MkdirAll(dirName, os.ModePerm)
 	if err != nil {
 		logrus.Errorln("mkdir error: ", err)
-		return err
+		return
 	}
 
-	if file, err = os.Create(filePath); err != nil {
+	var file *os.File
+	if file, err = os.CreateTemp(dirName, "."+filepath.Base(filePath)+".*.tmp"); err != nil {
 		return
 	}
-	defer func(file *os.File) {
+	tmp := file.Name()
+	defer func() {
+		if err != nil {
+			// 不保留写了一半的文件
+			_ = os.Remove(tmp)
+		}
+	}()
+
+	v := newVerifier(opt)
+	if _, err = io.Copy(io.MultiWriter(file, v), body); err != nil {
 		_ = file.Close()
-	}(file)
-	_, err = io.Copy(file, opt.Body)
-	if err != nil {
 		logrus.Errorln("Failed to save file:", err)
 		return
 	}
+	if err = file.Close(); err != nil {
+		return
+	}
+	if err = v.verify(); err != nil {
+		return
+	}
+	// CreateTemp 创建的文件权限为 0600
+	if err = os.Chmod(tmp, 0644); err != nil {
+		return
+	}
+	setModTime(tmp, opt.ModifyTime)
+	if err = os.Rename(tmp, filePath); err != nil {
+		return
+	}
 
 	logrus.Infof("[Save] %s -> %s ", opt.From, filePath)
 
-	return
+	return true, nil
+}
 
+// sameContent 判断本地文件的内容是否与 content 完全相同
+func sameContent(filePath string, content []byte) bool {
+	old, err := os.ReadFile(filePath)
+	return err == nil && bytes.Equal(old, content)
+}
+
+// setModTime 将文件的修改时间设置为源端的修改时间
+func setModTime(filePath string, t time.Time) {
+	if t.IsZero() {
+		return
+	}
+	if info, err := os.Stat(filePath); err == nil && info.ModTime().Equal(t) {
+		return
+	}
+	if err := os.Chtimes(filePath, time.Now(), t); err != nil {
+		logrus.Warningf("set mtime of %s error: %v", filePath, err)
+	}
 }
@@ -0,0 +1,287 @@
+package job
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// blockHandler 记录每次运行是否为试运行，release 关闭前一直阻塞
+type blockHandler struct {
+	mu      sync.Mutex
+	dryRuns []bool
+	release chan struct{}
+}
+
+func (h *blockHandler) Handle(s *Session) error {
+	h.mu.Lock()
+	h.dryRuns = append(h.dryRuns, s.DryRun)
+	h.mu.Unlock()
+	select {
+	case <-h.release:
+	case <-s.Ctx.Done():
+	}
+	return nil
+}
+
+// setDataDir 将运行数据目录设置为测试的临时目录，测试结束后恢复
+func setDataDir(t *testing.T) {
+	t.Helper()
+	dataDir := DataDir
+	DataDir = t.TempDir()
+	t.Cleanup(func() { DataDir = dataDir })
+}
+
+func waitIdle(t *testing.T, j *Job) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for j.Running() {
+		if time.Now().After(deadline) {
+			t.Fatal("job is still running")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestPlanOverlap(t *testing.T) {
+	setDataDir(t)
+
+	h := &blockHandler{release: make(chan struct{})}
+	j := &Job{Key: "plan", Name: "plan", Opts: &Opts{}, Overlap: OverlapQueue, Handler: h}
+
+	p, err := j.Plan()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j.LastPlan() != p {
+		t.Error("LastPlan() is not the running plan")
+	}
+	if _, err = j.Plan(); !errors.Is(err, ErrRunning) {
+		t.Errorf("second Plan() = %v, want ErrRunning", err)
+	}
+	// 试运行期间的触发排队，试运行结束后再运行
+	if state, err := j.Trigger(TriggerManual); err != nil || state != TriggerQueued {
+		t.Errorf("Trigger() during plan = %q, %v, want queued", state, err)
+	}
+	close(h.release)
+	waitIdle(t, j)
+
+	if _, done, _ := p.State(); !done {
+		t.Error("plan is not done")
+	}
+	h.mu.Lock()
+	if len(h.dryRuns) != 2 || !h.dryRuns[0] || h.dryRuns[1] {
+		t.Errorf("runs = %v, want a plan followed by a run", h.dryRuns)
+	}
+	h.mu.Unlock()
+
+	// 正式运行期间拒绝试运行
+	h.release = make(chan struct{})
+	if _, err = j.Trigger(TriggerManual); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = j.Plan(); !errors.Is(err, ErrRunning) {
+		t.Errorf("Plan() during run = %v, want ErrRunning", err)
+	}
+	if j.LastPlan() != p {
+		t.Error("rejected Plan() replaced LastPlan()")
+	}
+	close(h.release)
+	waitIdle(t, j)
+}
+
+func TestMarshalWhileRunning(t *testing.T) {
+	setDataDir(t)
+
+	h := &blockHandler{release: make(chan struct{})}
+	j := &Job{Key: "marshal", Name: "marshal", Opts: &Opts{}, Handler: h}
+	if _, err := j.Trigger(TriggerManual); err != nil {
+		t.Fatal(err)
+	}
+	// 运行状态在运行中和结束时被修改，与 API 的编码并发进行
+	stop, done := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if _, err := json.Marshal(j); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	time.Sleep(10 * time.Millisecond)
+	close(h.release)
+	waitIdle(t, j)
+	close(stop)
+	<-done
+
+	data, err := json.Marshal(j)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got struct {
+		Status string `json:"status"`
+	}
+	if err = json.Unmarshal(data, &got); err != nil || got.Status != "success" {
+		t.Errorf("status = %q, %v, want success", got.Status, err)
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	setDataDir(t)
+
+	h := &blockHandler{release: make(chan struct{})}
+	j := &Job{Key: "update", Name: "update", Dest: "/old", Opts: &Opts{}, Handler: h}
+	if _, err := j.Trigger(TriggerManual); err != nil {
+		t.Fatal(err)
+	}
+	// 管理界面提交 GET 得到的完整任务，其中带有运行状态
+	edit := &Job{Key: "other", Name: "renamed", Dest: "/new", Status: "idle", Opts: &Opts{Clean: true}, Handler: h}
+	if err := j.Update(edit); !errors.Is(err, ErrRunning) {
+		t.Errorf("Update() while running = %v, want ErrRunning", err)
+	}
+	if j.Dest != "/old" {
+		t.Errorf("Dest = %q, modified while running", j.Dest)
+	}
+	close(h.release)
+	waitIdle(t, j)
+
+	if err := j.Update(edit); err != nil {
+		t.Fatal(err)
+	}
+	if j.Name != "renamed" || j.Dest != "/new" || !j.Opts.Clean {
+		t.Errorf("config not updated: %+v", j)
+	}
+	if j.Key != "update" || j.Status != "success" {
+		t.Errorf("key = %q, status = %q, want the original values", j.Key, j.Status)
+	}
+}
+
+func TestCancelAfterTrigger(t *testing.T) {
+	setDataDir(t)
+
+	h := &blockHandler{release: make(chan struct{})}
+	j := &Job{Key: "cancel", Name: "cancel", Opts: &Opts{}, Overlap: OverlapReplace, Handler: h}
+
+	// 运行刚开始时就可以取消
+	if _, err := j.Trigger(TriggerManual); err != nil {
+		t.Fatal(err)
+	}
+	if !j.Cancel() {
+		t.Error("Cancel() right after Trigger() = false")
+	}
+	waitIdle(t, j)
+	if j.Status != "cancelled" {
+		t.Errorf("status = %q, want cancelled", j.Status)
+	}
+
+	// replace 策略的触发立即取消当前运行，然后重新运行
+	if _, err := j.Trigger(TriggerManual); err != nil {
+		t.Fatal(err)
+	}
+	if state, err := j.Trigger(TriggerManual); err != nil || state != TriggerRestarting {
+		t.Fatalf("Trigger() = %q, %v, want restarting", state, err)
+	}
+	close(h.release)
+	waitIdle(t, j)
+	runs, err := Runs(j.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 3 || runs[0].Status != "success" || runs[1].Status != "cancelled" {
+		t.Errorf("runs = %+v, want cancelled then success", runs)
+	}
+}
+
+func TestSaveStrm(t *testing.T) {
+	dest := t.TempDir()
+	filePath := filepath.Join(dest, "a.strm")
+	modified := time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC)
+	save := func(body io.Reader) (bool, error) {
+		return Save(SaveOpt{
+			Opts:       &Opts{Overwrite: true},
+			From:       "/media",
+			Dest:       dest,
+			Name:       "/media/a.strm",
+			Source:     "/media/a.mkv",
+			ModifyTime: modified,
+			Body:       body,
+		})
+	}
+	stat := func() os.FileInfo {
+		t.Helper()
+		info, err := os.Stat(filePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return info
+	}
+
+	// 新文件写入后修改时间为源端的修改时间
+	if written, err := save(strings.NewReader("http://alist/d/a.mkv")); err != nil || !written {
+		t.Fatalf("Save() = %v, %v, want written", written, err)
+	}
+	first := stat()
+	if !first.ModTime().Equal(modified) {
+		t.Errorf("mtime = %s, want %s", first.ModTime(), modified)
+	}
+	if first.Mode().Perm() != 0644 {
+		t.Errorf("mode = %s, want 0644", first.Mode().Perm())
+	}
+
+	// 内容相同时不重写，文件和修改时间都不变
+	if written, err := save(strings.NewReader("http://alist/d/a.mkv")); err != nil || written {
+		t.Errorf("Save() identical = %v, %v, want not written", written, err)
+	}
+	if info := stat(); !os.SameFile(first, info) || !info.ModTime().Equal(modified) {
+		t.Error("identical strm was rewritten")
+	}
+
+	// 内容变化时替换为新的文件
+	if written, err := save(strings.NewReader("http://alist/d/a.mkv?sign=x")); err != nil || !written {
+		t.Errorf("Save() changed = %v, %v, want written", written, err)
+	}
+	if body, _ := os.ReadFile(filePath); string(body) != "http://alist/d/a.mkv?sign=x" {
+		t.Errorf("a.strm = %q, want the new content", body)
+	}
+	if info := stat(); os.SameFile(first, info) || !info.ModTime().Equal(modified) {
+		t.Error("changed strm was not replaced with a new file")
+	}
+
+	// 读取内容失败时保留原文件
+	if _, err := save(&failingReader{r: strings.NewReader("http://other"), n: 4}); err == nil {
+		t.Error("Save() with a failing body should return an error")
+	}
+	if body, _ := os.ReadFile(filePath); string(body) != "http://alist/d/a.mkv?sign=x" {
+		t.Errorf("a.strm = %q after a failed write, want it kept", body)
+	}
+
+	// 重命名失败时不留下临时文件
+	blocked := filepath.Join(dest, "b.strm")
+	if err := os.MkdirAll(filepath.Join(blocked, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Save(SaveOpt{Opts: &Opts{Overwrite: true}, From: "/media", Dest: dest, Name: "/media/b.strm", Body: strings.NewReader("http://b")}); err == nil {
+		t.Error("Save() over a directory should return an error")
+	}
+	entries, err := os.ReadDir(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if strings.HasSuffix(e.Name(), ".tmp") {
+			t.Errorf("temporary file %s was left behind", e.Name())
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package job
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"astrm/utils/mediainfo"
+)
+
+// organize 按文件名解析出的剧名、年份和季重新组织 From 下的相对路径
+//
+// 剧集保存为 "剧名 (年份)/Season NN/文件名"，电影保存为 "片名 (年份)/文件名"，
+// 文件名中缺少的信息从上级目录中补全，无法解析出标题时保持原路径
+func organize(name string) (string, mediainfo.Info, bool) {
+	dir, base := path.Split(name)
+	stem := strings.TrimSuffix(base, path.Ext(base))
+	if stem == "" {
+		return name, mediainfo.Info{}, false
+	}
+	file := mediainfo.Parse(stem)
+	parent := parseDir(dir)
+
+	var info mediainfo.Info
+	var folder string
+	switch {
+	case file.IsEpisode():
+		info = file
+		if info.Title == "" {
+			info.Title, info.Year = parent.Title, parent.Year
+		} else if info.Year == 0 && strings.EqualFold(info.Title, parent.Title) {
+			info.Year = parent.Year
+		}
+		if info.Season == 0 {
+			info.Season = parent.Season
+		}
+		if info.Season == 0 {
+			info.Season = 1
+		}
+		if info.Title == "" {
+			return name, info, false
+		}
+		folder = path.Join(showFolder(info), seasonFolder(info.Season))
+	case file.Year > 0 && file.Title != "":
+		info = file
+		folder = showFolder(info)
+	case parent.Title != "":
+		// 海报、字幕等无法单独识别的文件跟随所在目录
+		info = parent
+		folder = showFolder(info)
+		if info.Season > 0 {
+			folder = path.Join(folder, seasonFolder(info.Season))
+		}
+	case file.Title != "":
+		info = file
+		folder = showFolder(info)
+	default:
+		return name, info, false
+	}
+	return path.Join("/", folder, base), info, true
+}
+
+// parseDir 从近到远解析目录名，取最近的标题和季
+func parseDir(dir string) (info mediainfo.Info) {
+	parts := strings.Split(strings.Trim(dir, "/"), "/")
+	for i := len(parts) - 1; i >= 0 && info.Title == ""; i-- {
+		if parts[i] == "" {
+			continue
+		}
+		p := mediainfo.Parse(parts[i])
+		if info.Season == 0 {
+			info.Season = p.Season
+		}
+		info.Title, info.Year = p.Title, p.Year
+	}
+	return
+}
+
+var folderReplacer = strings.NewReplacer(`\`, "", "/", "", ":", "", "*", "", "?", "", `"`, "", "<", "", ">", "", "|", "")
+
+func showFolder(info mediainfo.Info) string {
+	name := strings.TrimSpace(folderReplacer.Replace(info.Title))
+	if info.Year > 0 {
+		name = fmt.Sprintf("%s (%d)", name, info.Year)
+	}
+	return name
+}
+
+func seasonFolder(season int) string {
+	return fmt.Sprintf("Season %02d", season)
+}
+
+type tvShowNfo struct {
+	XMLName xml.Name `xml:"tvshow"`
+	Title   string   `xml:"title"`
+	Year    int      `xml:"year,omitempty"`
+}
+
+type episodeNfo struct {
+	XMLName   xml.Name `xml:"episodedetails"`
+	ShowTitle string   `xml:"showtitle"`
+	Season    int      `xml:"season"`
+	Episode   int      `xml:"episode"`
+}
+
+type movieNfo struct {
+	XMLName xml.Name `xml:"movie"`
+	Title   string   `xml:"title"`
+	Year    int      `xml:"year,omitempty"`
+}
+
+type nfoFile struct {
+	Path string
+	Data any
+}
+
+// nfoFiles 整理目录后需要为 strm 文件生成的 nfo 文件
+//
+// 剧集生成 tvshow.nfo 和同名的 episodedetails，电影只在识别出年份时生成同名的 movie
+func (opt *SaveOpt) nfoFiles() []nfoFile {
+	if !opt.Organize || opt.IsExtra {
+		return nil
+	}
+	_, info, ok := organize(strings.Replace(opt.Name, opt.From, "", -1))
+	if !ok {
+		return nil
+	}
+	filePath := opt.FmtSavePath()
+	nfoPath := strings.TrimSuffix(filePath, filepath.Ext(filePath)) + ".nfo"
+	if info.IsEpisode() {
+		return []nfoFile{
+			{Path: filepath.Join(filepath.Dir(filepath.Dir(filePath)), "tvshow.nfo"), Data: tvShowNfo{Title: info.Title, Year: info.Year}},
+			{Path: nfoPath, Data: episodeNfo{ShowTitle: info.Title, Season: info.Season, Episode: info.Episode}},
+		}
+	}
+	if info.Year > 0 && info.Season == 0 {
+		return []nfoFile{{Path: nfoPath, Data: movieNfo{Title: info.Title, Year: info.Year}}}
+	}
+	return nil
+}
+
+func (n nfoFile) marshal() ([]byte, error) {
+	data, err := xml.MarshalIndent(n.Data, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(`<?xml version="1.0" encoding="utf-8" standalone="yes"?>`+"\n"), data...), nil
+}
+
+// exists nfo 只在不存在时生成，不覆盖用户或刮削器写入的内容
+func (n nfoFile) exists() bool {
+	_, err := os.Stat(n.Path)
+	return err == nil
+}
@@ -0,0 +1,35 @@
+package job
+
+import "testing"
+
+func TestOrganize(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+		ok   bool
+	}{
+		{"/Show.Name.2020.S01E02.1080p.mkv", "/Show Name (2020)/Season 01/Show.Name.2020.S01E02.1080p.mkv", true},
+		{"/庆余年/第二季/第05集.mkv", "/庆余年/Season 02/第05集.mkv", true},
+		// 集数缺少的剧名、年份和季从目录中补全
+		{"/Show Name (2019)/Season 2/EP03.mkv", "/Show Name (2019)/Season 02/EP03.mkv", true},
+		{"/[Group] Show Name/[Group] Show Name - 01 [1080p].mkv", "/Show Name/Season 01/[Group] Show Name - 01 [1080p].mkv", true},
+		{"/tv/Show Name/01.mkv", "/Show Name/Season 01/01.mkv", true},
+		// 电影
+		{"/movies/The.Matrix.1999.2160p.mkv", "/The Matrix (1999)/The.Matrix.1999.2160p.mkv", true},
+		{"/Blade Runner 2049 (2017)/Blade Runner 2049 (2017).mkv", "/Blade Runner 2049 (2017)/Blade Runner 2049 (2017).mkv", true},
+		// 海报、字幕跟随所在目录
+		{"/流浪地球 (2019)/poster.jpg", "/流浪地球 (2019)/poster.jpg", true},
+		{"/Show Name/Season 1/fanart.jpg", "/Show Name/Season 01/fanart.jpg", true},
+		// 无法识别时保持原路径
+		{"/EP01.mkv", "/EP01.mkv", false},
+		{"/.mkv", "/.mkv", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _, ok := organize(tt.name)
+			if got != tt.want || ok != tt.ok {
+				t.Errorf("organize(%q) = %q, %v, want %q, %v", tt.name, got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
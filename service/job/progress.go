@@ -0,0 +1,94 @@
+package job
+
+import (
+	"time"
+)
+
+// Progress 正在运行的任务的实时进度
+type Progress struct {
+	RunId      string    `json:"runId"`
+	Start      time.Time `json:"start"`
+	Elapsed    float64   `json:"elapsed"` // 已运行秒数
+	Scanned    int64     `json:"scanned"` // 已遍历的目录数
+	Queued     int64     `json:"queued"`  // 已提交处理的文件数
+	Stats      Stats     `json:"stats"`
+	Current    string    `json:"current"`    // 当前处理的路径
+	Throughput float64   `json:"throughput"` // 每秒处理的文件数
+	ETA        float64   `json:"eta"`        // 预计剩余秒数，-1 表示未知
+}
+
+// Scanned 记录遍历了一个目录
+func (s *Session) Scanned(dir string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.scanned++
+	s.current = dir
+}
+
+// Queued 记录提交了一个待处理的文件
+func (s *Session) Queued() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.queued++
+}
+
+// Progress 返回当前进度，剩余时间根据上一次成功运行的文件数和耗时估算
+func (s *Session) Progress() *Progress {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	elapsed := time.Since(s.Start).Seconds()
+	p := &Progress{
+		RunId:   s.Id,
+		Start:   s.Start,
+		Elapsed: elapsed,
+		Scanned: s.scanned,
+		Queued:  s.queued,
+		Stats:   s.stats,
+		Current: s.current,
+		ETA:     -1,
+	}
+
+	done := s.stats.Written + s.stats.Skipped + s.stats.Extras + s.stats.Errors
+	if elapsed > 0 {
+		p.Throughput = float64(done) / elapsed
+	}
+
+	if prev := s.previous; prev != nil {
+		expected := prev.Stats.Listed
+		if s.stats.Listed > expected {
+			expected = s.stats.Listed
+		}
+		if p.Throughput > 0 {
+			p.ETA = float64(expected-done) / p.Throughput
+		} else {
+			p.ETA = prev.End.Sub(prev.Start).Seconds() - elapsed
+		}
+		if p.ETA < 0 {
+			p.ETA = 0
+		}
+	}
+	return p
+}
+
+// Progress 返回任务正在运行的进度，任务未运行时返回 nil
+func (j *Job) Progress() *Progress {
+	j.mu.Lock()
+	s := j.session
+	j.mu.Unlock()
+	if s == nil {
+		return nil
+	}
+	return s.Progress()
+}
+
+// lastSuccess 返回任务最近一次成功运行的记录
+func lastSuccess(key string) *Record {
+	records, _ := Runs(key)
+	for _, r := range records {
+		if r.Status == "success" {
+			return r
+		}
+	}
+	return nil
+}
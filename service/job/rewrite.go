@@ -0,0 +1,40 @@
+package job
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sync"
+)
+
+// Rewrite 路径重写规则，作用于相对 Dest 的路径（以 / 分隔，不以 / 开头，如 Show/S01/E01.strm），Replace 中可以使用 $1、${name} 引用分组
+type Rewrite struct {
+	Match   string `yaml:"match" json:"match"`
+	Replace string `yaml:"replace" json:"replace"`
+}
+
+var rewriteCache sync.Map
+
+func (r Rewrite) compile() (*regexp.Regexp, error) {
+	if re, ok := rewriteCache.Load(r.Match); ok {
+		return re.(*regexp.Regexp), nil
+	}
+	re, err := regexp.Compile(r.Match)
+	if err != nil {
+		return nil, fmt.Errorf("rewrite %q: %w", r.Match, err)
+	}
+	rewriteCache.Store(r.Match, re)
+	return re, nil
+}
+
+// rewritePath 依次应用重写规则，结果不会超出 Dest
+func rewritePath(rules []Rewrite, rel string) string {
+	for _, r := range rules {
+		re, err := r.compile()
+		if err != nil {
+			continue
+		}
+		rel = re.ReplaceAllString(rel, r.Replace)
+	}
+	return path.Clean("/" + rel)
+}
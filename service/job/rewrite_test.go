@@ -0,0 +1,64 @@
+package job
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestRewritePath(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules []Rewrite
+		rel   string
+		want  string
+	}{
+		{"no rules", nil, "Show/a.strm", "/Show/a.strm"},
+		{"anchored", []Rewrite{{`^Show/`, "Series/"}}, "Show/a.strm", "/Series/a.strm"},
+		{"anchored no match", []Rewrite{{`^Show/`, "Series/"}}, "tv/Show/a.strm", "/tv/Show/a.strm"},
+		{"unanchored", []Rewrite{{`(?i)/s(\d{2})/`, "/Season $1/"}}, "Show/S01/a.strm", "/Show/Season 01/a.strm"},
+		{"named group", []Rewrite{{`^(?P<show>[^/]+)/\[[^\]]*\]\s*`, "${show}/"}}, "Show/[Group] a.strm", "/Show/a.strm"},
+		{"in order", []Rewrite{{`^Show/`, "Series/"}, {`^Series/`, "TV/"}}, "Show/a.strm", "/TV/a.strm"},
+		{"invalid rule skipped", []Rewrite{{`(`, ""}, {`a\.strm$`, "b.strm"}}, "Show/a.strm", "/Show/b.strm"},
+		{"stays in dest", []Rewrite{{`^`, "../../"}}, "Show/a.strm", "/Show/a.strm"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rewritePath(tt.rules, tt.rel); got != tt.want {
+				t.Errorf("rewritePath(%q) = %q, want %q", tt.rel, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFmtSavePathRewrite(t *testing.T) {
+	tests := []struct {
+		name  string
+		deep  int
+		rules []Rewrite
+		want  string
+	}{
+		{"deep 0", 0, nil, "/strm/Show/S01/a.strm"},
+		{"deep 1", 1, nil, "/strm/tv/Show/S01/a.strm"},
+		// 规则匹配的路径不以 / 开头，与 deep 无关
+		{"anchored deep 0", 0, []Rewrite{{`^Show/`, "Series/"}}, "/strm/Series/S01/a.strm"},
+		{"anchored deep 1", 1, []Rewrite{{`^tv/`, "TV/"}}, "/strm/TV/Show/S01/a.strm"},
+		{"anchored no leading slash deep 0", 0, []Rewrite{{`^/`, "x/"}}, "/strm/Show/S01/a.strm"},
+		{"unanchored deep 0", 0, []Rewrite{{`/S(\d{2})/`, "/Season $1/"}}, "/strm/Show/Season 01/a.strm"},
+		{"unanchored deep 1", 1, []Rewrite{{`/S(\d{2})/`, "/Season $1/"}}, "/strm/tv/Show/Season 01/a.strm"},
+		{"capture groups deep 0", 0, []Rewrite{{`^([^/]+)/S(\d{2})/`, "$1 - Season $2/"}}, "/strm/Show - Season 01/a.strm"},
+		{"capture groups deep 1", 1, []Rewrite{{`^([^/]+)/([^/]+)/`, "$2 ($1)/"}}, "/strm/Show (tv)/S01/a.strm"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opt := &SaveOpt{
+				Opts: &Opts{Deep: tt.deep, Rewrite: tt.rules},
+				From: "/media/tv",
+				Dest: "/strm",
+				Name: "/media/tv/Show/S01/a.strm",
+			}
+			if got := opt.FmtSavePath(); got != filepath.FromSlash(tt.want) {
+				t.Errorf("FmtSavePath() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,389 @@
+package job
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// 计划中对单个文件的处理方式
+const (
+	ActionCreate    = "create"
+	ActionOverwrite = "overwrite"
+	ActionSkip      = "skip"
+	ActionExtra     = "extra"
+	ActionDelete    = "delete"
+)
+
+type PlanItem struct {
+	Action string `json:"action"`
+	Source string `json:"source,omitempty"`
+	Path   string `json:"path"`
+}
+
+// Plan 试运行的结果，列出任务将会对每个文件进行的操作
+type Plan struct {
+	mu      sync.Mutex
+	Created time.Time      `json:"created"`
+	Summary map[string]int `json:"summary"`
+	Items   []PlanItem     `json:"-"`
+	Error   string         `json:"error,omitempty"`
+	Done    bool           `json:"done"` // 试运行是否已经结束，未结束时 Items 只包含已经处理的文件
+}
+
+func (p *Plan) add(action, source, path string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.Items = append(p.Items, PlanItem{Action: action, Source: source, Path: path})
+	p.Summary[action]++
+}
+
+// finish 结束试运行，按本地路径排序，使并发生成的计划顺序稳定
+func (p *Plan) finish(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	sort.SliceStable(p.Items, func(i, k int) bool { return p.Items[i].Path < p.Items[k].Path })
+	if err != nil {
+		p.Error = err.Error()
+	}
+	p.Done = true
+}
+
+// State 返回计划的汇总信息
+func (p *Plan) State() (summary map[string]int, done bool, err string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	summary = make(map[string]int, len(p.Summary))
+	for action, n := range p.Summary {
+		summary[action] = n
+	}
+	return summary, p.Done, p.Error
+}
+
+// Page 按 action 过滤后分页返回计划条目，page 从 1 开始
+func (p *Plan) Page(action string, page, pageSize int) (items []PlanItem, total int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	filtered := p.Items
+	if action != "" {
+		filtered = nil
+		for _, item := range p.Items {
+			if item.Action == action {
+				filtered = append(filtered, item)
+			}
+		}
+	}
+	total = len(filtered)
+	start := (page - 1) * pageSize
+	if page < 1 || pageSize < 1 || start >= total {
+		return []PlanItem{}, total
+	}
+	end := start + pageSize
+	if end > total {
+		end = total
+	}
+	return filtered[start:end], total
+}
+
+// Session 任务的一次运行，由 Handler 在遍历源端时使用
+//
+// DryRun 为 true 时不写入任何内容，只把将要进行的操作记录到 Plan 中
+type Session struct {
+	Id      string
+	Start   time.Time
+	Ctx     context.Context
+	Job     *Job
+	DryRun  bool
+	Plan    *Plan
+	Cleaner *Cleaner
+	Index   *Index
+
+	mu        sync.Mutex
+	stats     Stats
+	samples   []string
+	scanned   int64               // 已遍历的目录数
+	queued    int64               // 已提交处理的文件数
+	current   string              // 当前处理的路径
+	previous  *Record             // 上一次成功运行的记录，用于估算剩余时间
+	changed   map[string]struct{} // 有文件写入或删除的本地目录
+	added     []string            // 新增的 strm 文件
+	bandwidth *bandwidth          // extra 下载限速
+	nfos      map[string]struct{} // 本次运行已处理的 nfo 文件
+	backends  []string            // 本次运行实际使用的源端服务器，按首次使用排序
+}
+
+// Stats 单次运行的文件统计
+type Stats struct {
+	Listed   int64 `json:"listed"`   // 源端列出的文件数
+	Written  int64 `json:"written"`  // 写入的 strm 文件数
+	Added    int64 `json:"added"`    // 其中新增的 strm 文件数
+	Skipped  int64 `json:"skipped"`  // 未变化而跳过的文件数
+	Deferred int64 `json:"deferred"` // 源端暂时无法生成内容，推迟到下次运行的文件数
+	Extras   int64 `json:"extras"`   // 下载的 extra 文件数
+	Removed  int64 `json:"removed"`  // 同步清理的文件数
+	Errors   int64 `json:"errors"`   // 出错次数
+}
+
+// maxErrorSamples 运行记录中保留的错误信息条数
+const maxErrorSamples = 10
+
+func newSession(ctx context.Context, j *Job, dryRun bool) *Session {
+	s := &Session{Id: uuid.NewString(), Start: time.Now(), Ctx: ctx, Job: j, DryRun: dryRun, changed: map[string]struct{}{}, nfos: map[string]struct{}{}}
+	if dryRun {
+		s.Plan = &Plan{Created: time.Now(), Summary: map[string]int{}}
+	} else {
+		s.previous = lastSuccess(j.Key)
+	}
+	s.bandwidth = newBandwidth(j.Opts.ExtraRate)
+	if j.Opts.Clean {
+		var err error
+		if s.Cleaner, err = OpenCleaner(j.Key); err != nil {
+			logrus.Warningf("[Clean] job name: %s, load manifest error: %v", j.Name, err)
+		}
+	}
+	if j.Opts.Index {
+		var err error
+		if s.Index, err = OpenIndex(j.Key); err != nil {
+			logrus.Warningf("[Index] job name: %s, load index error: %v", j.Name, err)
+		}
+		s.Index.Config(j.indexConfig())
+	}
+	return s
+}
+
+// Stats 返回当前的统计快照
+func (s *Session) Stats() Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stats
+}
+
+func (s *Session) count(f func(st *Stats)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f(&s.stats)
+}
+
+// Listed 记录从源端列出了一个文件
+func (s *Session) Listed() {
+	s.count(func(st *Stats) { st.Listed++ })
+}
+
+// Error 记录运行中出现的错误
+func (s *Session) Error(err error) {
+	logrus.Errorln(err)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stats.Errors++
+	if len(s.samples) < maxErrorSamples {
+		s.samples = append(s.samples, err.Error())
+	}
+}
+
+// Save 写入文件，试运行时只记录操作
+func (s *Session) Save(opt SaveOpt) error {
+	if !s.DryRun {
+		if opt.Ctx == nil {
+			opt.Ctx = s.Ctx
+		}
+		if open := opt.Open; open != nil && opt.IsExtra && s.bandwidth != nil {
+			opt.Open = func() (io.ReadCloser, error) {
+				body, err := open()
+				if err != nil {
+					return nil, err
+				}
+				return s.bandwidth.reader(s.Ctx, body), nil
+			}
+		}
+		filePath := opt.FmtSavePath()
+		_, statErr := os.Stat(filePath)
+		written, err := Save(opt)
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.current = opt.Source
+		switch {
+		case err != nil:
+		case !written:
+			s.stats.Skipped++
+		case opt.IsExtra:
+			s.stats.Extras++
+		default:
+			s.stats.Written++
+			if statErr != nil {
+				s.stats.Added++
+				s.added = append(s.added, filePath)
+			}
+		}
+		if written {
+			s.changed[filepath.Dir(filePath)] = struct{}{}
+			s.Cleaner.Wrote(filePath)
+		}
+		if err == nil {
+			s.saveNfo(&opt)
+		}
+		return err
+	}
+
+	filePath := opt.FmtSavePath()
+	action := ActionSkip
+	if opt.IsWrite(filePath, opt.ModifyTime) && !(opt.IsExtra && opt.Same(filePath)) && !opt.sameStrm(filePath) {
+		if opt.IsExtra {
+			action = ActionExtra
+		} else if _, err := os.Stat(filePath); err != nil {
+			action = ActionCreate
+		} else {
+			action = ActionOverwrite
+		}
+	}
+	s.Plan.add(action, opt.Source, filePath)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, n := range s.newNfo(&opt) {
+		s.Plan.add(ActionCreate, opt.Source, n.Path)
+	}
+	return nil
+}
+
+// sameStrm 试运行时判断 strm 内容是否与本地文件相同，与正式运行一样内容相同时不会重写，
+// 未提供内容（如直链模式）时返回 false
+func (opt *SaveOpt) sameStrm(filePath string) bool {
+	if opt.IsExtra || opt.Body == nil {
+		return false
+	}
+	content, err := io.ReadAll(opt.Body)
+	return err == nil && sameContent(filePath, content)
+}
+
+// newNfo 返回本次运行中第一次遇到且本地不存在的 nfo 文件，调用时需持有锁
+func (s *Session) newNfo(opt *SaveOpt) (files []nfoFile) {
+	for _, n := range opt.nfoFiles() {
+		if _, ok := s.nfos[n.Path]; ok {
+			continue
+		}
+		s.nfos[n.Path] = struct{}{}
+		if !n.exists() {
+			files = append(files, n)
+		}
+	}
+	return
+}
+
+// saveNfo 为整理后的 strm 文件生成缺少的 nfo 文件，调用时需持有锁
+func (s *Session) saveNfo(opt *SaveOpt) {
+	for _, n := range s.newNfo(opt) {
+		data, err := n.marshal()
+		if err == nil {
+			_, err = writeFile(&SaveOpt{From: opt.Source}, n.Path, bytes.NewReader(data))
+		}
+		if err != nil {
+			logrus.Errorf("[Organize] write %s error: %v", n.Path, err)
+			continue
+		}
+		s.changed[filepath.Dir(n.Path)] = struct{}{}
+		s.Cleaner.Wrote(n.Path)
+	}
+}
+
+// Skip 记录因索引未变化而跳过的文件
+func (s *Session) Skip(opt SaveOpt) {
+	s.count(func(st *Stats) { st.Skipped++ })
+	if s.DryRun {
+		s.Plan.add(ActionSkip, opt.Source, opt.FmtSavePath())
+	}
+}
+
+// Defer 记录源端暂时无法生成内容而推迟到下次运行写入的文件
+func (s *Session) Defer(opt SaveOpt, err error) {
+	logrus.Warningf("[Defer] %s: %v", opt.Source, err)
+	s.count(func(st *Stats) { st.Deferred++ })
+}
+
+// Backend 记录本次运行使用的源端服务器，源端有多个服务器故障转移时由 Handler 调用
+func (s *Session) Backend(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, b := range s.backends {
+		if b == name {
+			return
+		}
+	}
+	s.backends = append(s.backends, name)
+}
+
+// Changed 返回本次运行中有文件写入或删除的本地目录，按路径排序
+func (s *Session) Changed() (dirs []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for dir := range s.changed {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+	return
+}
+
+// Added 返回本次运行新增的 strm 文件，按路径排序
+func (s *Session) Added() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	added := append([]string(nil), s.added...)
+	sort.Strings(added)
+	return added
+}
+
+// Finish 在遍历结束后保存索引并执行同步清理
+func (s *Session) Finish() (err error) {
+	j := s.Job
+	if s.Ctx.Err() != nil {
+		// 被取消时源端列表不完整
+		s.Cleaner.Fail()
+		s.Index.Fail()
+	}
+	s.Index.Carry(func(name string, e *IndexEntry) {
+		s.Cleaner.Keep(e.Path)
+		s.count(func(st *Stats) { st.Skipped++ })
+		if s.DryRun {
+			s.Plan.add(ActionSkip, name, e.Path)
+		}
+	})
+	if !s.DryRun {
+		if err := s.Index.Save(); err != nil {
+			logrus.Errorf("[Index] job name: %s, save index error: %v", j.Name, err)
+		}
+	}
+
+	if s.Cleaner == nil {
+		return
+	}
+	var removed []string
+	if removed, err = s.Cleaner.Clean(j.Opts, j.Dest, s.DryRun); err != nil {
+		s.Error(err)
+		return
+	}
+	if s.DryRun {
+		for _, p := range removed {
+			s.Plan.add(ActionDelete, "", p)
+		}
+		return
+	}
+	j.mu.Lock()
+	j.LastRemoved = removed
+	j.mu.Unlock()
+	s.mu.Lock()
+	s.stats.Removed = int64(len(removed))
+	for _, p := range removed {
+		s.changed[filepath.Dir(p)] = struct{}{}
+	}
+	s.mu.Unlock()
+	if len(removed) > 0 {
+		logrus.Infof("[Clean] job name: %s, removed %d files", j.Name, len(removed))
+	}
+	return
+}
@@ -0,0 +1,87 @@
+package job
+
+import (
+	"net/url"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// StrmData strm 内容模板中可以使用的字段
+type StrmData struct {
+	Endpoint string    // alist 地址
+	Path     string    // 文件在 alist 中的路径
+	Name     string    // 文件名
+	Sign     string    // alist 签名
+	Size     int64     // 文件大小
+	Modified time.Time // 修改时间
+	URL      string    // alist 下载地址，即 alist_url 模式的内容
+
+	rawURL func() (string, error)
+	raw    *string
+}
+
+func NewStrmData(endpoint, path, sign string, size int64, modified time.Time, url string, rawURL func() (string, error)) *StrmData {
+	return &StrmData{
+		Endpoint: endpoint,
+		Path:     path,
+		Name:     path[strings.LastIndex(path, "/")+1:],
+		Sign:     sign,
+		Size:     size,
+		Modified: modified,
+		URL:      url,
+		rawURL:   rawURL,
+	}
+}
+
+// RawURL 网盘原始直链，只有模板用到时才会请求 alist 获取
+func (d *StrmData) RawURL() (string, error) {
+	if d.raw == nil {
+		if d.rawURL == nil {
+			return "", nil
+		}
+		raw, err := d.rawURL()
+		if err != nil {
+			return "", err
+		}
+		d.raw = &raw
+	}
+	return *d.raw, nil
+}
+
+var templateFuncs = template.FuncMap{
+	// pathEscape 逐段转义路径，保留 /
+	"pathEscape": func(p string) string {
+		segments := strings.Split(p, "/")
+		for i, s := range segments {
+			segments[i] = url.PathEscape(s)
+		}
+		return strings.Join(segments, "/")
+	},
+	"queryEscape": url.QueryEscape,
+	"replace": func(old, new, s string) string {
+		return strings.ReplaceAll(s, old, new)
+	},
+	"trimPrefix": func(prefix, s string) string {
+		return strings.TrimPrefix(s, prefix)
+	},
+	"trimSuffix": func(suffix, s string) string {
+		return strings.TrimSuffix(s, suffix)
+	},
+}
+
+// StrmTemplate 解析任务的 strm 内容模板，未配置时返回 nil
+func (j *Job) StrmTemplate() (*template.Template, error) {
+	if j.Template == "" {
+		return nil, nil
+	}
+	return template.New(j.Name).Funcs(templateFuncs).Parse(j.Template)
+}
+
+// UsesRawURL 判断 strm 内容是否依赖会过期的网盘直链
+func (j *Job) UsesRawURL() bool {
+	if j.Template != "" {
+		return strings.Contains(j.Template, "RawURL")
+	}
+	return j.Mode == "raw_url"
+}
@@ -0,0 +1,92 @@
+package job
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStrmTemplate(t *testing.T) {
+	modified := time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC)
+	tests := []struct {
+		name     string
+		template string
+		want     string
+		rawCalls int
+	}{
+		{"endpoint", "{{.Endpoint}}", "http://alist", 0},
+		{"path", "{{.Path}}", "/media/电影 #1.mkv", 0},
+		{"name", "{{.Name}}", "电影 #1.mkv", 0},
+		{"sign", "{{.Sign}}", "s1", 0},
+		{"size", "{{.Size}}", "5", 0},
+		{"modified", `{{.Modified.Format "2006-01-02"}}`, "2024-01-02", 0},
+		{"url", "{{.URL}}", "http://alist/d/media/a.mkv?sign=s1", 0},
+		// RawURL 只在用到时请求一次
+		{"raw url", "{{.RawURL}}", "http://raw", 1},
+		{"raw url twice", "{{.RawURL}}|{{.RawURL}}", "http://raw|http://raw", 1},
+		{"path escape", "{{.Endpoint}}/d{{pathEscape .Path}}", "http://alist/d/media/%E7%94%B5%E5%BD%B1%20%231.mkv", 0},
+		{"query escape", "?p={{queryEscape .Path}}", "?p=%2Fmedia%2F%E7%94%B5%E5%BD%B1+%231.mkv", 0},
+		{"replace", `{{replace "/media" "/mnt" .Path}}`, "/mnt/电影 #1.mkv", 0},
+		{"trim", `{{trimPrefix "/media/" .Path | trimSuffix ".mkv"}}`, "电影 #1", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var rawCalls int
+			data := NewStrmData("http://alist", "/media/电影 #1.mkv", "s1", 5, modified, "http://alist/d/media/a.mkv?sign=s1",
+				func() (string, error) {
+					rawCalls++
+					return "http://raw", nil
+				})
+			j := &Job{Name: tt.name, Template: tt.template}
+			tpl, err := j.StrmTemplate()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var buf strings.Builder
+			if err = tpl.Execute(&buf, data); err != nil {
+				t.Fatal(err)
+			}
+			if buf.String() != tt.want {
+				t.Errorf("content = %q, want %q", buf.String(), tt.want)
+			}
+			if rawCalls != tt.rawCalls {
+				t.Errorf("RawURL fetched %d times, want %d", rawCalls, tt.rawCalls)
+			}
+			if got := j.UsesRawURL(); got != (tt.rawCalls > 0) {
+				t.Errorf("UsesRawURL() = %v", got)
+			}
+		})
+	}
+
+	// 获取直链失败时执行模板返回错误
+	data := NewStrmData("", "/a.mkv", "", 0, modified, "", func() (string, error) { return "", errors.New("alist down") })
+	tpl, _ := (&Job{Template: "{{.RawURL}}"}).StrmTemplate()
+	if err := tpl.Execute(&strings.Builder{}, data); err == nil {
+		t.Error("Execute() should return the RawURL error")
+	}
+
+	if tpl, err := (&Job{}).StrmTemplate(); tpl != nil || err != nil {
+		t.Errorf("StrmTemplate() without a template = %v, %v, want nil", tpl, err)
+	}
+}
+
+func TestValidateTemplate(t *testing.T) {
+	tests := []struct {
+		template string
+		wantErr  bool
+	}{
+		{"", false},
+		{"{{.URL}}", false},
+		{"{{.Endpoint}}/d{{pathEscape .Path}}?sign={{.Sign}}", false},
+		{"{{.URL", true},       // 语法错误
+		{"{{.Unknown}}", true}, // 字段名错误在校验时执行模板发现
+		{"{{unknownFunc .Path}}", true},
+	}
+	for _, tt := range tests {
+		err := (&Job{Name: "validate", Template: tt.template}).Validate()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Validate(%q) = %v, want error %v", tt.template, err, tt.wantErr)
+		}
+	}
+}
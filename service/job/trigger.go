@@ -0,0 +1,114 @@
+package job
+
+import (
+	"context"
+	"errors"
+
+	"github.com/sirupsen/logrus"
+)
+
+// 任务重叠运行策略
+const (
+	OverlapSkip    = "skip"    // 任务运行中时拒绝新的触发
+	OverlapQueue   = "queue"   // 排队一次，当前运行结束后再运行
+	OverlapReplace = "replace" // 取消当前运行并重新开始
+)
+
+// 触发结果
+const (
+	TriggerStarted    = "started"
+	TriggerQueued     = "queued"
+	TriggerRestarting = "restarting"
+)
+
+var (
+	ErrRunning = errors.New("job is already running")
+	ErrQueued  = errors.New("job is already running and a run is queued")
+)
+
+// Run 由 cron 调度触发
+func (j *Job) Run() {
+	state, err := j.Trigger(TriggerCron)
+	if err != nil {
+		logrus.Warnf("[skip] job name: %s, job id: %s, %v", j.Name, j.Id, err)
+		return
+	}
+	if state != TriggerStarted {
+		logrus.Infof("[%s] job name: %s, job id: %s", state, j.Name, j.Id)
+	}
+}
+
+// Trigger 按照 Overlap 策略在后台运行任务，被拒绝时返回错误
+func (j *Job) Trigger(trigger string) (state string, err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if !j.running {
+		j.running = true
+		j.goLoop(trigger)
+		return TriggerStarted, nil
+	}
+
+	switch j.Overlap {
+	case OverlapQueue:
+		if j.pending != "" {
+			return "", ErrQueued
+		}
+		j.pending = trigger
+		return TriggerQueued, nil
+	case OverlapReplace:
+		j.pending = trigger
+		if j.cancel != nil {
+			j.cancel()
+		}
+		return TriggerRestarting, nil
+	default:
+		return "", ErrRunning
+	}
+}
+
+// goLoop 创建运行的 ctx 后在后台运行任务，调用时需持有锁，
+// 这样 Cancel 和 replace 策略的触发在运行开始前也能取消本次运行
+func (j *Job) goLoop(trigger string) {
+	ctx, cancel := context.WithCancel(context.Background())
+	j.cancel = cancel
+	go j.loop(ctx, trigger)
+}
+
+// loop 运行任务，结束后继续运行排队的触发
+func (j *Job) loop(ctx context.Context, trigger string) {
+	for {
+		j.start(ctx, trigger)
+
+		j.mu.Lock()
+		j.cancel()
+		if j.pending == "" {
+			j.cancel = nil
+			j.running = false
+			j.mu.Unlock()
+			return
+		}
+		trigger, j.pending = j.pending, ""
+		ctx, j.cancel = context.WithCancel(context.Background())
+		j.mu.Unlock()
+	}
+}
+
+// Running 判断任务是否正在运行
+func (j *Job) Running() bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.running
+}
+
+// Cancel 取消正在运行的任务以及排队的触发，任务未在运行时返回 false
+func (j *Job) Cancel() bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.cancel == nil {
+		return false
+	}
+	j.pending = ""
+	j.cancel()
+	return true
+}
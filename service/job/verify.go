@@ -0,0 +1,94 @@
+package job
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"strings"
+)
+
+// 支持校验的哈希算法，按优先级排列
+var hashAlgorithms = []struct {
+	name string
+	new  func() hash.Hash
+}{
+	{"sha256", sha256.New},
+	{"sha1", sha1.New},
+	{"md5", md5.New},
+}
+
+// pickHash 选择源端提供的第一个支持的哈希
+func pickHash(hashes map[string]string) (name, expected string, h hash.Hash) {
+	for _, algo := range hashAlgorithms {
+		if v := hashes[algo.name]; v != "" {
+			return algo.name, strings.ToLower(v), algo.new()
+		}
+	}
+	return
+}
+
+// Same 判断本地文件是否与源端的大小和哈希一致，源端没有提供大小时返回 false
+func (opt *SaveOpt) Same(filePath string) bool {
+	if opt.Size <= 0 {
+		return false
+	}
+	info, err := os.Stat(filePath)
+	if err != nil || info.Size() != opt.Size {
+		return false
+	}
+	_, expected, h := pickHash(opt.Hash)
+	if h == nil {
+		return true
+	}
+	file, err := os.Open(filePath)
+	if err != nil {
+		return false
+	}
+	defer func(file *os.File) {
+		_ = file.Close()
+	}(file)
+	if _, err = io.Copy(h, file); err != nil {
+		return false
+	}
+	return hex.EncodeToString(h.Sum(nil)) == expected
+}
+
+// verifier 在写入时统计大小并计算哈希，用于校验下载是否完整
+type verifier struct {
+	opt      *SaveOpt
+	size     int64
+	algo     string
+	expected string
+	h        hash.Hash
+}
+
+func newVerifier(opt *SaveOpt) *verifier {
+	v := &verifier{opt: opt}
+	v.algo, v.expected, v.h = pickHash(opt.Hash)
+	return v
+}
+
+func (v *verifier) Write(p []byte) (int, error) {
+	v.size += int64(len(p))
+	if v.h != nil {
+		v.h.Write(p)
+	}
+	return len(p), nil
+}
+
+func (v *verifier) verify() error {
+	if v.opt.Size > 0 && v.size != v.opt.Size {
+		return fmt.Errorf("verify %s: size %d, expected %d", v.opt.Source, v.size, v.opt.Size)
+	}
+	if v.h != nil {
+		if sum := hex.EncodeToString(v.h.Sum(nil)); sum != v.expected {
+			return fmt.Errorf("verify %s: %s %s, expected %s", v.opt.Source, v.algo, sum, v.expected)
+		}
+	}
+	return nil
+}
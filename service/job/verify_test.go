@@ -0,0 +1,191 @@
+package job
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// failingReader 读取 n 个字节后返回错误，模拟下载中断
+type failingReader struct {
+	r io.Reader
+	n int
+}
+
+func (f *failingReader) Read(p []byte) (int, error) {
+	if f.n <= 0 {
+		return 0, errors.New("connection reset")
+	}
+	if len(p) > f.n {
+		p = p[:f.n]
+	}
+	n, err := f.r.Read(p)
+	f.n -= n
+	return n, err
+}
+
+func TestSaveExtra(t *testing.T) {
+	backoff := saveBackoff
+	saveBackoff = time.Millisecond
+	t.Cleanup(func() { saveBackoff = backoff })
+
+	const content = "subtitle"
+	sha := sha256.Sum256([]byte(content))
+	md := md5.Sum([]byte(content))
+	var (
+		errOpen = errors.New("dial timeout")
+		ok      = func() (io.ReadCloser, error) { return io.NopCloser(strings.NewReader(content)), nil }
+		short   = func() (io.ReadCloser, error) { return io.NopCloser(strings.NewReader(content[:4])), nil }
+		broken  = func() (io.ReadCloser, error) {
+			return io.NopCloser(&failingReader{r: strings.NewReader(content), n: 4}), nil
+		}
+		fail = func() (io.ReadCloser, error) { return nil, errOpen }
+	)
+	tests := []struct {
+		name      string
+		size      int64
+		hash      map[string]string
+		existing  string                          // 本地已有的内容
+		opens     []func() (io.ReadCloser, error) // 依次使用，用完后重复最后一个
+		wantOpens int
+		written   bool
+		wantErr   bool
+	}{
+		{name: "size and sha256", size: 8, hash: map[string]string{"sha256": hex.EncodeToString(sha[:])}, opens: []func() (io.ReadCloser, error){ok}, wantOpens: 1, written: true},
+		{name: "upper case md5", size: 8, hash: map[string]string{"md5": strings.ToUpper(hex.EncodeToString(md[:]))}, opens: []func() (io.ReadCloser, error){ok}, wantOpens: 1, written: true},
+		{name: "no size or hash", opens: []func() (io.ReadCloser, error){ok}, wantOpens: 1, written: true},
+		{name: "bad size", size: 9, opens: []func() (io.ReadCloser, error){ok}, wantOpens: SaveRetries + 1, wantErr: true},
+		{name: "bad hash", size: 8, hash: map[string]string{"md5": strings.Repeat("0", 32)}, opens: []func() (io.ReadCloser, error){ok}, wantOpens: SaveRetries + 1, wantErr: true},
+		{name: "truncated then ok", size: 8, opens: []func() (io.ReadCloser, error){short, ok}, wantOpens: 2, written: true},
+		{name: "transient errors", size: 8, opens: []func() (io.ReadCloser, error){fail, broken, ok}, wantOpens: 3, written: true},
+		{name: "always failing", opens: []func() (io.ReadCloser, error){fail}, wantOpens: SaveRetries + 1, wantErr: true},
+		// 本地文件的大小和哈希都相同时不下载
+		{name: "same file", size: 8, hash: map[string]string{"sha256": hex.EncodeToString(sha[:])}, existing: content, opens: []func() (io.ReadCloser, error){fail}, wantOpens: 0},
+		{name: "changed file", size: 8, hash: map[string]string{"sha256": hex.EncodeToString(sha[:])}, existing: "subtitlX", opens: []func() (io.ReadCloser, error){ok}, wantOpens: 1, written: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dest := t.TempDir()
+			filePath := filepath.Join(dest, "a.srt")
+			if tt.existing != "" {
+				if err := os.WriteFile(filePath, []byte(tt.existing), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			var opens int
+			written, err := Save(SaveOpt{
+				Opts:    &Opts{Overwrite: true},
+				From:    "/media",
+				Dest:    dest,
+				Name:    "/media/a.srt",
+				Source:  "/media/a.srt",
+				IsExtra: true,
+				Size:    tt.size,
+				Hash:    tt.hash,
+				Open: func() (io.ReadCloser, error) {
+					open := tt.opens[min(opens, len(tt.opens)-1)]
+					opens++
+					return open()
+				},
+			})
+			if (err != nil) != tt.wantErr || written != tt.written {
+				t.Errorf("Save() = %v, %v, want written %v, error %v", written, err, tt.written, tt.wantErr)
+			}
+			if opens != tt.wantOpens {
+				t.Errorf("opened %d times, want %d", opens, tt.wantOpens)
+			}
+			want := tt.existing
+			if tt.written {
+				want = content
+			}
+			if body, _ := os.ReadFile(filePath); string(body) != want {
+				t.Errorf("a.srt = %q, want %q", body, want)
+			}
+			// 校验失败时不留下临时文件
+			if entries, _ := os.ReadDir(dest); len(entries) > 1 {
+				t.Errorf("dest has %d files, want no temporary files left", len(entries))
+			}
+		})
+	}
+}
+
+func TestSaveRetryCancel(t *testing.T) {
+	backoff := saveBackoff
+	saveBackoff = time.Hour
+	t.Cleanup(func() { saveBackoff = backoff })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	opened := make(chan struct{}, 1)
+	done := make(chan error, 1)
+	go func() {
+		_, err := Save(SaveOpt{
+			Opts:    &Opts{},
+			Dest:    t.TempDir(),
+			Name:    "/a.srt",
+			IsExtra: true,
+			Ctx:     ctx,
+			Open: func() (io.ReadCloser, error) {
+				opened <- struct{}{}
+				return nil, errors.New("dial timeout")
+			},
+		})
+		done <- err
+	}()
+	<-opened
+	// 取消后不再等待退避
+	cancel()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Save() = %v, want context.Canceled", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Save() kept waiting for the backoff after cancel")
+	}
+}
+
+func TestBandwidth(t *testing.T) {
+	if newBandwidth(0) != nil {
+		t.Error("newBandwidth(0) should not limit")
+	}
+
+	// 64KB/s，两个并发的下载共用限速：第一块不等待，之后的 96KB 至少需要 1.5s
+	b := newBandwidth(64)
+	data := strings.Repeat("x", 64*1024)
+	start := time.Now()
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			r := b.reader(context.Background(), io.NopCloser(strings.NewReader(data)))
+			n, err := io.Copy(io.Discard, r)
+			if err == nil && n != int64(len(data)) {
+				err = io.ErrShortWrite
+			}
+			errs <- err
+		}()
+	}
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 1400*time.Millisecond {
+		t.Errorf("2 x 64KB at 64KB/s took %s, want at least 1.5s", elapsed)
+	}
+
+	// 取消后停止等待
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	r := newBandwidth(1).reader(ctx, io.NopCloser(strings.NewReader(data)))
+	if _, err := io.Copy(io.Discard, r); !errors.Is(err, context.Canceled) {
+		t.Errorf("read with a cancelled ctx = %v, want context.Canceled", err)
+	}
+}
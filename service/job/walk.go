@@ -0,0 +1,365 @@
+package job
+
+import (
+	"astrm/utils/concurrent"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Entry 源端列出的文件或目录
+type Entry struct {
+	Path     string            // 源端完整路径，以 / 分隔
+	IsDir    bool              // 是否为目录
+	Size     int64             // 文件大小
+	Modified time.Time         // 修改时间
+	Hash     map[string]string // 文件哈希，如 {"md5": "..."}
+	Data     any               // 源端附带的数据，如 alist 的 Content，Walk 不使用
+}
+
+// ErrDeferred 源端暂时无法生成 strm 内容，Strm 或 StrmData 返回包装了它的错误时，
+// 本地已是最新的文件跳过，其余文件推迟到下次运行，都不计为错误
+var ErrDeferred = errors.New("deferred")
+
+// FileSource 可以用 Walk 遍历的源端
+type FileSource interface {
+	// List 列出目录下的文件和子目录
+	List(ctx context.Context, dir string) ([]*Entry, error)
+	// Strm 返回文件对应的 strm 内容
+	Strm(ctx context.Context, e *Entry) (string, error)
+	// Open 打开 extra 文件的内容
+	Open(ctx context.Context, e *Entry) (io.ReadCloser, error)
+}
+
+// TemplateSource 为 strm 模板提供完整字段的源端，
+// 未实现时模板中的 URL 为 Strm 返回的内容，Endpoint、Sign 和 RawURL 为空
+type TemplateSource interface {
+	StrmData(ctx context.Context, e *Entry) (*StrmData, error)
+}
+
+// Pacer 自行按 Opts.Interval 控制请求间隔的源端，Walk 不再对列目录限速，
+// 例如 alist 由服务器的限速统一控制列目录、获取直链和下载
+type Pacer interface {
+	// Pace 返回带有请求间隔（秒）的 ctx，遍历中对源端的所有调用都使用该 ctx
+	Pace(ctx context.Context, interval float64) context.Context
+}
+
+func (e *Entry) modified() string {
+	if e.Modified.IsZero() {
+		return ""
+	}
+	return e.Modified.UTC().Format(time.RFC3339Nano)
+}
+
+func (e *Entry) hash() string {
+	var pairs []string
+	for k, v := range e.Hash {
+		pairs = append(pairs, k+":"+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// walkTask 等待保存的单个文件
+type walkTask struct {
+	entry *Entry
+	opt   *SaveOpt
+}
+
+// walkDir 等待列出的目录，modified 为父目录列出时得到的修改时间，未知时为空
+type walkDir struct {
+	path     string
+	modified string
+}
+
+// listed 并发列出的单个目录
+type listed struct {
+	entries []*Entry
+	pruned  bool     // 目录未变化，没有列出
+	dirs    []string // 未变化的目录上次记录的子目录
+	err     error
+	done    chan struct{}
+}
+
+// Walk 遍历源端的 From 目录并生成 strm 文件
+//
+// 所有源端共用的流程：Filters/Extra/include/exclude/大小过滤、deep、maxDepth、parallel、interval、
+// overwrite、路径重写、strm 模板、增量索引、同步清理和试运行
+func Walk(s *Session, src FileSource) (err error) {
+	j := s.Job
+	ctx := s.Ctx
+
+	var filterRegex, extraRegex *regexp.Regexp
+	if filterRegex, err = regexp.Compile(j.Opts.Filters); err != nil {
+		return
+	}
+	if j.Opts.Extra != "" {
+		if extraRegex, err = regexp.Compile(j.Opts.Extra); err != nil {
+			return
+		}
+	}
+	var pathFilter *PathFilter
+	if pathFilter, err = j.Opts.PathFilter(); err != nil {
+		return
+	}
+	tpl, err := j.StrmTemplate()
+	if err != nil {
+		return
+	}
+
+	// 请求间隔控制，并发列目录时共用
+	var (
+		throttleMu sync.Mutex
+		last       time.Time
+	)
+	interval := time.Duration(j.Opts.Interval * float64(time.Second))
+	if p, ok := src.(Pacer); ok {
+		ctx, interval = p.Pace(ctx, j.Opts.Interval), 0
+	}
+	throttle := func(ctx context.Context) error {
+		if interval <= 0 {
+			return nil
+		}
+		throttleMu.Lock()
+		defer throttleMu.Unlock()
+		if wait := interval - time.Since(last); wait > 0 {
+			timer := time.NewTimer(wait)
+			defer timer.Stop()
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		last = time.Now()
+		return nil
+	}
+
+	strmContent := func(e *Entry) (string, error) {
+		if tpl == nil {
+			return src.Strm(ctx, e)
+		}
+		var data *StrmData
+		if ts, ok := src.(TemplateSource); ok {
+			var dataErr error
+			if data, dataErr = ts.StrmData(ctx, e); dataErr != nil {
+				return "", dataErr
+			}
+		} else {
+			body, strmErr := src.Strm(ctx, e)
+			if strmErr != nil {
+				return "", strmErr
+			}
+			data = NewStrmData("", e.Path, "", e.Size, e.Modified, body, nil)
+		}
+		var buf strings.Builder
+		err := tpl.Execute(&buf, data)
+		return buf.String(), err
+	}
+
+	process := func(_ context.Context, t walkTask) error {
+		e, o := t.entry, t.opt
+		if o.IsExtra {
+			// 试运行时用于判断本地文件是否相同
+			o.Size = e.Size
+			o.Hash = e.Hash
+		}
+		if s.DryRun {
+			// 直链会过期，试运行时不获取，其他模式比较 strm 内容，内容相同的文件正式运行时不会重写
+			if !o.IsExtra && !j.UsesRawURL() {
+				if body, strmErr := strmContent(e); strmErr == nil {
+					o.Body = strings.NewReader(body)
+				}
+			}
+			return s.Save(*o)
+		}
+
+		var body string
+		if o.IsExtra {
+			o.Open = func() (io.ReadCloser, error) {
+				return src.Open(ctx, e)
+			}
+		} else {
+			var strmErr error
+			if body, strmErr = strmContent(e); errors.Is(strmErr, ErrDeferred) {
+				// 没有写入的文件不在索引中，下次运行需要重新列出所在目录
+				s.Index.Fail()
+				if !o.IsWrite(o.FmtSavePath(), o.ModifyTime) {
+					return s.Save(*o)
+				}
+				s.Defer(*o, strmErr)
+				return nil
+			} else if strmErr != nil {
+				s.Error(strmErr)
+				return strmErr
+			}
+			o.Body = strings.NewReader(body)
+		}
+		if saveErr := s.Save(*o); saveErr != nil {
+			s.Error(saveErr)
+			return saveErr
+		}
+		s.Index.Put(e.Path, &IndexEntry{
+			Size:     e.Size,
+			Modified: e.modified(),
+			Hash:     e.hash(),
+			Content:  body,
+			Path:     o.FmtSavePath(),
+		})
+		return nil
+	}
+
+	// 判断文件自上次运行以来是否未发生变化，直链可能过期，不重新获取，开启覆盖时总是重新生成
+	unchanged := func(e *Entry, o *SaveOpt, savePath string) bool {
+		x := s.Index.Lookup(e.Path)
+		if !x.Same(e.Size, e.modified(), e.hash()) || x.Path != savePath {
+			return false
+		}
+		if o.IsExtra {
+			return true
+		}
+		if j.UsesRawURL() {
+			return !j.Opts.Overwrite
+		}
+		body, strmErr := strmContent(e)
+		// 无法生成内容时沿用索引中未变化的文件
+		return errors.Is(strmErr, ErrDeferred) || strmErr == nil && body == x.Content
+	}
+
+	concurrency := j.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	pool := concurrent.NewPool(ctx, concurrency, concurrency, process)
+	workers := j.Opts.Parallel
+	if workers < 1 {
+		workers = 1
+	}
+
+	for _, from := range strings.Split(j.From, "\n") {
+		from = strings.TrimSpace(from)
+		if from == "" {
+			continue
+		}
+		s.Cleaner.Root((&SaveOpt{Opts: j.Opts, From: from, Dest: j.Dest, Name: from}).RootDir())
+
+		// 按层遍历，同一层的目录并发列出，但按顺序处理，结果与逐个遍历时相同
+		level := []walkDir{{path: from}}
+		for depth := 0; len(level) > 0 && ctx.Err() == nil; depth++ {
+			results := make([]*listed, len(level))
+			for i := range results {
+				results[i] = &listed{done: make(chan struct{})}
+			}
+			lister := concurrent.NewPool(ctx, workers, len(level), func(ctx context.Context, i int) error {
+				r := results[i]
+				defer close(r.done)
+				if r.dirs, r.pruned = s.Index.Prune(level[i].path, level[i].modified); r.pruned {
+					return nil
+				}
+				if r.err = throttle(ctx); r.err == nil {
+					r.entries, r.err = src.List(ctx, level[i].path)
+				}
+				return nil
+			})
+			for i := range level {
+				if lister.Submit(i) != nil {
+					break
+				}
+			}
+
+			var next []walkDir
+			for i, d := range level {
+				dir := d.path
+				r := results[i]
+				select {
+				case <-r.done:
+				case <-ctx.Done():
+				}
+				if ctx.Err() != nil {
+					break
+				}
+				if r.pruned {
+					// 目录中的文件在 Finish 时从索引继承，子目录的修改时间未知，继续列出
+					for _, sub := range r.dirs {
+						next = append(next, walkDir{path: sub})
+					}
+					continue
+				}
+				if r.err != nil {
+					err = fmt.Errorf("list %s error: %w", dir, r.err)
+					s.Cleaner.Fail()
+					s.Index.Fail()
+					s.Error(err)
+					continue
+				}
+				s.Scanned(dir)
+
+				var subs []string
+				for _, e := range r.entries {
+					if e.IsDir {
+						if j.Opts.MaxDepth > 0 && depth >= j.Opts.MaxDepth {
+							continue
+						}
+						if pathFilter.Dir(e.Path) {
+							subs = append(subs, e.Path)
+							next = append(next, walkDir{path: e.Path, modified: e.modified()})
+						}
+						continue
+					}
+
+					ext := filepath.Ext(e.Path)
+					media := filterRegex.MatchString(ext)
+					if !media && (extraRegex == nil || !extraRegex.MatchString(ext)) {
+						continue
+					}
+					if !pathFilter.File(e.Path, e.Size, media) {
+						continue
+					}
+
+					s.Listed()
+					o := &SaveOpt{
+						Opts:       j.Opts,
+						From:       from,
+						Dest:       j.Dest,
+						Name:       e.Path,
+						ModifyTime: e.Modified,
+						Source:     e.Path,
+						IsExtra:    !media,
+					}
+					if media {
+						o.Name = strings.TrimSuffix(o.Name, ext) + ".strm"
+					}
+					savePath := o.FmtSavePath()
+					s.Cleaner.Keep(savePath)
+					if unchanged(e, o, savePath) {
+						s.Index.Put(e.Path, s.Index.Lookup(e.Path))
+						s.Skip(*o)
+						continue
+					}
+					s.Queued()
+					if pool.Submit(walkTask{entry: e, opt: o}) != nil {
+						break
+					}
+				}
+				s.Index.Listed(dir, d.modified, subs)
+			}
+			_ = lister.Wait()
+			level = next
+		}
+	}
+	if poolErr := pool.Wait(); poolErr != nil && err == nil {
+		err = poolErr
+	}
+
+	if finishErr := s.Finish(); finishErr != nil {
+		err = finishErr
+	}
+	return
+}
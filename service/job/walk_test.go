@@ -0,0 +1,201 @@
+package job
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// memSource 内存中的源端，key 为文件路径
+type memSource map[string]string
+
+func (m memSource) List(_ context.Context, dir string) (entries []*Entry, err error) {
+	dirs := map[string]struct{}{}
+	for p, content := range m {
+		rel, ok := strings.CutPrefix(p, strings.TrimSuffix(dir, "/")+"/")
+		if !ok {
+			continue
+		}
+		if name, _, isDir := strings.Cut(rel, "/"); isDir {
+			if _, ok := dirs[name]; !ok {
+				dirs[name] = struct{}{}
+				entries = append(entries, &Entry{Path: dir + "/" + name, IsDir: true})
+			}
+			continue
+		}
+		entries = append(entries, &Entry{Path: p, Size: int64(len(content)), Modified: time.Unix(1700000000, 0)})
+	}
+	return
+}
+
+func (m memSource) Strm(_ context.Context, e *Entry) (string, error) {
+	return "http://source" + e.Path, nil
+}
+
+func (m memSource) Open(_ context.Context, e *Entry) (io.ReadCloser, error) {
+	return io.NopCloser(strings.NewReader(m[e.Path])), nil
+}
+
+func TestWalkDryRun(t *testing.T) {
+	dest := t.TempDir()
+	src := memSource{
+		"/media/Show/S01E01.mkv": "video",
+		"/media/Show/S01E01.srt": "subtitle",
+		"/media/Show/S01E02.srt": "new subtitle",
+	}
+	// 本地已有内容相同但修改时间较早的字幕，试运行时应判断为不需要下载
+	same := filepath.Join(dest, "media", "Show", "S01E01.srt")
+	if err := os.MkdirAll(filepath.Dir(same), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(same, []byte("subtitle"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(same, time.Unix(1600000000, 0), time.Unix(1600000000, 0)); err != nil {
+		t.Fatal(err)
+	}
+
+	j := &Job{
+		Name:        "test",
+		From:        "/media",
+		Dest:        dest,
+		Concurrency: 2,
+		Opts:        &Opts{Deep: 1, Filters: `(?i)\.mkv$`, Extra: `(?i)\.srt$`},
+	}
+	s := newSession(context.Background(), j, true)
+	if err := Walk(s, src); err != nil {
+		t.Fatal(err)
+	}
+	s.Plan.finish(nil)
+
+	want := map[string]string{
+		filepath.Join(dest, "media", "Show", "S01E01.strm"): ActionCreate,
+		same: ActionSkip,
+		filepath.Join(dest, "media", "Show", "S01E02.srt"): ActionExtra,
+	}
+	if len(s.Plan.Items) != len(want) {
+		t.Fatalf("plan = %+v, want %d items", s.Plan.Items, len(want))
+	}
+	for _, item := range s.Plan.Items {
+		if want[item.Path] != item.Action {
+			t.Errorf("plan %s = %s, want %s", item.Path, item.Action, want[item.Path])
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dest, "media", "Show", "S01E01.strm")); err == nil {
+		t.Error("dry run wrote a strm file")
+	}
+}
+
+// modSource 为目录设置修改时间的 memSource，并记录列出的目录
+type modSource struct {
+	memSource
+	dirs   map[string]time.Time
+	listed []string
+}
+
+func (m *modSource) List(ctx context.Context, dir string) ([]*Entry, error) {
+	m.listed = append(m.listed, dir)
+	entries, err := m.memSource.List(ctx, dir)
+	for _, e := range entries {
+		if e.IsDir {
+			e.Modified = m.dirs[e.Path]
+		}
+	}
+	return entries, err
+}
+
+func TestWalkIndex(t *testing.T) {
+	setDataDir(t)
+
+	dest := t.TempDir()
+	t0 := time.Unix(1700000000, 0)
+	src := &modSource{
+		memSource: memSource{"/media/Show/Season 1/E01.mkv": "video"},
+		dirs:      map[string]time.Time{"/media/Show": t0, "/media/Show/Season 1": t0},
+	}
+	j := &Job{
+		Key:         "index",
+		Name:        "index",
+		From:        "/media",
+		Dest:        dest,
+		Concurrency: 1,
+		Opts:        &Opts{Filters: `(?i)\.mkv$`, Index: true},
+	}
+	run := func() {
+		t.Helper()
+		src.listed = nil
+		if err := Walk(newSession(context.Background(), j, false), src); err != nil {
+			t.Fatal(err)
+		}
+	}
+	exists := func(p string) bool {
+		_, err := os.Stat(filepath.Join(dest, filepath.FromSlash(p)))
+		return err == nil
+	}
+
+	run()
+	if !exists("Show/Season 1/E01.strm") {
+		t.Fatal("E01.strm not written")
+	}
+
+	// 新增的剧集只改变 Season 1 的修改时间，Show 不再列出，但仍然继续遍历其子目录
+	src.memSource["/media/Show/Season 1/E02.mkv"] = "video"
+	src.dirs["/media/Show/Season 1"] = t0.Add(time.Hour)
+	run()
+	if !exists("Show/Season 1/E02.strm") {
+		t.Error("new episode under an unchanged directory was not picked up")
+	}
+	for _, dir := range src.listed {
+		if dir == "/media/Show" {
+			t.Error("unchanged directory was listed")
+		}
+	}
+
+	// 修改影响输出的配置后索引失效，文件按新的路径重新生成
+	j.Opts.Deep = 1
+	run()
+	for _, p := range []string{"media/Show/Season 1/E01.strm", "media/Show/Season 1/E02.strm"} {
+		if !exists(p) {
+			t.Errorf("%s not written after the config changed", p)
+		}
+	}
+}
+
+func TestWalkDryRunOverwrite(t *testing.T) {
+	dest := t.TempDir()
+	src := memSource{
+		"/media/same.mkv":    "video",
+		"/media/changed.mkv": "video",
+	}
+	write := func(name, content string) string {
+		p := filepath.Join(dest, name)
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	same := write("same.strm", "http://source/media/same.mkv")
+	changed := write("changed.strm", "http://old/media/changed.mkv")
+
+	// 开启覆盖时，内容相同的 strm 正式运行不会重写，试运行也应报告为跳过
+	j := &Job{Name: "test", From: "/media", Dest: dest, Opts: &Opts{Filters: `(?i)\.mkv$`, Overwrite: true}}
+	s := newSession(context.Background(), j, true)
+	if err := Walk(s, src); err != nil {
+		t.Fatal(err)
+	}
+	s.Plan.finish(nil)
+
+	want := map[string]string{same: ActionSkip, changed: ActionOverwrite}
+	if len(s.Plan.Items) != len(want) {
+		t.Fatalf("plan = %+v, want %d items", s.Plan.Items, len(want))
+	}
+	for _, item := range s.Plan.Items {
+		if want[item.Path] != item.Action {
+			t.Errorf("plan %s = %s, want %s", item.Path, item.Action, want[item.Path])
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package local
+
+import (
+	"astrm/service/alist"
+	"astrm/service/job"
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Handler 从本地目录（如 rclone、CloudDrive 的挂载目录）生成 strm 文件
+//
+// strm 的内容由任务的 PathMap 将本地路径映射而来，Mode 为：
+// url 映射为 http 地址；alist_path 映射为 alist 路径；alist_url 映射为 alist 路径后生成 Alist 的下载地址
+type Handler struct {
+	Alist *alist.Server
+}
+
+func (h *Handler) Handle(s *job.Session) error {
+	return job.Walk(s, &source{Handler: h, job: s.Job})
+}
+
+type source struct {
+	*Handler
+	job *job.Job
+}
+
+func (src *source) List(_ context.Context, dir string) (entries []*job.Entry, err error) {
+	var items []os.DirEntry
+	if items, err = os.ReadDir(dir); err != nil {
+		return
+	}
+	for _, item := range items {
+		// 跳过隐藏文件，包括写入中的临时文件
+		if strings.HasPrefix(item.Name(), ".") {
+			continue
+		}
+		p := filepath.Join(dir, item.Name())
+		var info os.FileInfo
+		if info, err = os.Stat(p); err != nil {
+			// 失效的软链接
+			err = nil
+			continue
+		}
+		entries = append(entries, &job.Entry{
+			Path:     filepath.ToSlash(p),
+			IsDir:    info.IsDir(),
+			Size:     info.Size(),
+			Modified: info.ModTime(),
+		})
+	}
+	return
+}
+
+func (src *source) Strm(_ context.Context, e *job.Entry) (string, error) {
+	switch src.job.Mode {
+	case "alist_path":
+		return MapPath(src.job.PathMap, e.Path), nil
+	case "alist_url":
+		if src.Alist == nil {
+			return "", fmt.Errorf("alist %d not found", src.job.Alist)
+		}
+		return url.JoinPath(src.Alist.Endpoint, "/d/", MapPath(src.job.PathMap, e.Path))
+	default:
+		prefix, rel := cutPath(src.job.PathMap, e.Path)
+		u, err := url.Parse(prefix)
+		if err != nil || u.Scheme == "" {
+			return MapPath(src.job.PathMap, e.Path), nil
+		}
+		// 文件名中可能含有 # ? 等字符，只解析前缀，再拼接编码后的路径
+		u.Path = path.Join(u.Path, rel)
+		u.RawPath = ""
+		return u.String(), nil
+	}
+}
+
+func (src *source) Open(_ context.Context, e *job.Entry) (io.ReadCloser, error) {
+	return os.Open(filepath.FromSlash(e.Path))
+}
+
+// MapPath 按 "本地前缀 -> 目标前缀" 替换路径的前缀，不匹配时返回原路径
+func MapPath(pathMap, p string) string {
+	prefix, rel := cutPath(pathMap, p)
+	if prefix == "" {
+		return p
+	}
+	return strings.TrimSuffix(prefix, "/") + "/" + rel
+}
+
+// cutPath 返回映射后的前缀和剩余的相对路径，不匹配时前缀为空
+func cutPath(pathMap, p string) (prefix, rel string) {
+	from, to, ok := strings.Cut(pathMap, "->")
+	from, to = strings.TrimSpace(from), strings.TrimSpace(to)
+	if !ok || to == "" || !strings.HasPrefix(p, from) {
+		return "", p
+	}
+	return to, strings.TrimLeft(strings.TrimPrefix(p, from), "/")
+}
@@ -0,0 +1,144 @@
+package local
+
+import (
+	"astrm/service/alist"
+	"astrm/service/job"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// setDataDir 将任务的运行数据目录设置为测试的临时目录，测试结束后恢复
+func setDataDir(t *testing.T) {
+	t.Helper()
+	dataDir := job.DataDir
+	job.DataDir = t.TempDir()
+	t.Cleanup(func() { job.DataDir = dataDir })
+}
+
+func waitIdle(t *testing.T, j *job.Job) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for j.Running() {
+		if time.Now().After(deadline) {
+			t.Fatal("job is still running")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestHandle(t *testing.T) {
+	setDataDir(t)
+	src := filepath.ToSlash(t.TempDir())
+	files := map[string]string{
+		"movie #1.mkv":      "video",
+		"movie #1.srt":      "subtitle",
+		"readme.txt":        "text",
+		".movie.mkv.tmp":    "partial",
+		"Sample/sample.mkv": "video",
+		"Show/S01E01.mkv":   "video",
+	}
+	for name, body := range files {
+		p := filepath.Join(filepath.FromSlash(src), filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(body), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dest := t.TempDir()
+	j := &job.Job{
+		Key:     "local",
+		Name:    "local",
+		Source:  "local",
+		From:    src,
+		Dest:    dest,
+		Mode:    "url",
+		PathMap: src + " -> http://nas:8080/media",
+		Handler: &Handler{},
+		Opts:    &job.Opts{Filters: `\.mkv$`, Extra: `\.srt$`, Exclude: []string{"Sample"}},
+	}
+
+	// 试运行只生成计划，不写入文件
+	p, err := j.Plan()
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitIdle(t, j)
+	items, _ := p.Page("", 1, 100)
+	want := []job.PlanItem{
+		{Action: job.ActionCreate, Source: src + "/Show/S01E01.mkv", Path: filepath.Join(dest, "Show", "S01E01.strm")},
+		{Action: job.ActionExtra, Source: src + "/movie #1.srt", Path: filepath.Join(dest, "movie #1.srt")},
+		{Action: job.ActionCreate, Source: src + "/movie #1.mkv", Path: filepath.Join(dest, "movie #1.strm")},
+	}
+	if len(items) != len(want) {
+		t.Fatalf("plan = %+v, want %+v", items, want)
+	}
+	for i := range want {
+		if items[i] != want[i] {
+			t.Errorf("plan[%d] = %+v, want %+v", i, items[i], want[i])
+		}
+	}
+	if entries, _ := os.ReadDir(dest); len(entries) != 0 {
+		t.Errorf("plan wrote %d files", len(entries))
+	}
+
+	// 正式运行按 pathMap 生成地址，extra 文件直接复制
+	if _, err = j.Trigger(job.TriggerManual); err != nil {
+		t.Fatal(err)
+	}
+	waitIdle(t, j)
+	if j.Status != "success" {
+		t.Fatalf("status = %q, %s", j.Status, j.LastError)
+	}
+	results := map[string]string{
+		"movie #1.strm":       "http://nas:8080/media/movie%20%231.mkv",
+		"Show/S01E01.strm":    "http://nas:8080/media/Show/S01E01.mkv",
+		"movie #1.srt":        "subtitle",
+		"Sample/sample.strm":  "",
+		"readme.txt":          "",
+		".movie.mkv.tmp":      "",
+		".movie.mkv.tmp.strm": "",
+	}
+	for name, want := range results {
+		body, err := os.ReadFile(filepath.Join(dest, filepath.FromSlash(name)))
+		if want == "" {
+			if err == nil {
+				t.Errorf("%s should not be written", name)
+			}
+			continue
+		}
+		if err != nil || string(body) != want {
+			t.Errorf("%s = %q, %v, want %q", name, body, err, want)
+		}
+	}
+}
+
+func TestStrm(t *testing.T) {
+	e := &job.Entry{Path: "/mnt/cd/aliyun/电影/a #1.mkv"}
+	tests := []struct {
+		mode    string
+		pathMap string
+		alist   *alist.Server
+		want    string
+		wantErr bool
+	}{
+		{"url", "/mnt/cd -> http://nas/dav", nil, "http://nas/dav/aliyun/%E7%94%B5%E5%BD%B1/a%20%231.mkv", false},
+		// 目标不是 http 地址时只替换前缀
+		{"url", "/mnt/cd -> /media", nil, "/media/aliyun/电影/a #1.mkv", false},
+		{"url", "", nil, "/mnt/cd/aliyun/电影/a #1.mkv", false},
+		{"alist_path", "/mnt/cd/ -> /", nil, "/aliyun/电影/a #1.mkv", false},
+		{"alist_url", "/mnt/cd -> /", &alist.Server{Endpoint: "http://alist"}, "http://alist/d/aliyun/%E7%94%B5%E5%BD%B1/a%20%231.mkv", false},
+		{"alist_url", "/mnt/cd -> /", nil, "", true},
+	}
+	for _, tt := range tests {
+		src := &source{Handler: &Handler{Alist: tt.alist}, job: &job.Job{Mode: tt.mode, PathMap: tt.pathMap}}
+		got, err := src.Strm(context.Background(), e)
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("Strm() mode %s, pathMap %q = %q, %v, want %q", tt.mode, tt.pathMap, got, err, tt.want)
+		}
+	}
+}
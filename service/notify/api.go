@@ -0,0 +1,56 @@
+package notify
+
+import (
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// 事件类型
+const (
+	EventJobSuccess    = "job.success"          // 任务运行成功
+	EventJobFailed     = "job.failed"           // 任务运行失败
+	EventJobNewFiles   = "job.new_files"        // 任务新增了 strm 文件
+	EventRedirectError = "proxy.redirect_error" // 播放重定向失败
+)
+
+// Event 需要通知的事件
+type Event struct {
+	Type    string         `json:"type"`
+	Time    time.Time      `json:"time"`
+	Title   string         `json:"title"`
+	Message string         `json:"message"`
+	Data    map[string]any `json:"data,omitempty"`
+}
+
+var (
+	mu        sync.RWMutex
+	notifiers []*Notifier
+)
+
+// Setup 设置生效的通知器
+func Setup(list []*Notifier) {
+	mu.Lock()
+	defer mu.Unlock()
+	notifiers = list
+}
+
+// Emit 异步发送事件给所有订阅了该事件的通知器
+func Emit(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	mu.RLock()
+	defer mu.RUnlock()
+	for _, n := range notifiers {
+		if !n.accept(e) {
+			continue
+		}
+		go func(n *Notifier) {
+			if err := n.Send(e); err != nil {
+				logrus.Errorf("[Notify] %s send %s error: %v", n.Name, e.Type, err)
+			}
+		}(n)
+	}
+}
@@ -0,0 +1,253 @@
+package notify
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// 通知器类型
+const (
+	TypeWebhook = "webhook" // 以 JSON 格式 POST 整个事件
+	TypeHttp    = "http"    // 按模板生成请求体后 POST，用于对接各类聊天机器人
+	TypeSmtp    = "smtp"    // 发送邮件
+)
+
+type Notifier struct {
+	Name     string            `yaml:"name" json:"name"`
+	Type     string            `yaml:"type" json:"type"`
+	Enable   bool              `yaml:"enable" json:"enable"`
+	Events   []string          `yaml:"events" json:"events"`     // 订阅的事件，为空表示全部
+	Cooldown int               `yaml:"cooldown" json:"cooldown"` // 同一任务（或播放路径）同一事件的最短发送间隔（秒），0 表示不限制
+	URL      string            `yaml:"url" json:"url"`
+	Headers  map[string]string `yaml:"headers" json:"headers"`
+	Template string            `yaml:"template" json:"template"` // http 类型的请求体模板，语法见 text/template
+	Smtp     Smtp              `yaml:"smtp" json:"smtp"`
+
+	mu   sync.Mutex
+	last map[string]time.Time
+}
+
+type Smtp struct {
+	Host     string   `yaml:"host" json:"host"`
+	Port     int      `yaml:"port" json:"port"` // 465 使用 SSL 连接，其他端口服务器支持时使用 STARTTLS
+	Username string   `yaml:"username" json:"username"`
+	Password string   `yaml:"password" json:"password"`
+	From     string   `yaml:"from" json:"from"`
+	To       []string `yaml:"to" json:"to"`
+}
+
+var client = &http.Client{Timeout: 10 * time.Second}
+
+var funcs = template.FuncMap{
+	// json 将值编码为 JSON，用于在模板中安全地嵌入字符串
+	"json": func(v any) (string, error) {
+		bytes, err := json.Marshal(v)
+		return string(bytes), err
+	},
+}
+
+// Validate 检查配置是否完整
+func (n *Notifier) Validate() (err error) {
+	switch n.Type {
+	case TypeWebhook:
+	case TypeHttp:
+		if _, err = template.New(n.Name).Funcs(funcs).Parse(n.Template); err != nil {
+			return
+		}
+	case TypeSmtp:
+		if n.Smtp.Host == "" || len(n.Smtp.To) == 0 {
+			return fmt.Errorf("notifier %s: smtp host and to are required", n.Name)
+		}
+		return
+	default:
+		return fmt.Errorf("notifier %s: unknown type %q", n.Name, n.Type)
+	}
+	if n.URL == "" {
+		return fmt.Errorf("notifier %s: url is required", n.Name)
+	}
+	return
+}
+
+// accept 判断是否需要发送该事件，同时处理发送间隔
+func (n *Notifier) accept(e Event) bool {
+	if !n.Enable {
+		return false
+	}
+	if len(n.Events) > 0 {
+		var subscribed bool
+		for _, t := range n.Events {
+			subscribed = subscribed || t == e.Type
+		}
+		if !subscribed {
+			return false
+		}
+	}
+	if n.Cooldown <= 0 {
+		return true
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.last == nil {
+		n.last = map[string]time.Time{}
+	}
+	key := cooldownKey(e)
+	if e.Time.Sub(n.last[key]) < time.Duration(n.Cooldown)*time.Second {
+		return false
+	}
+	n.last[key] = e.Time
+	return true
+}
+
+// cooldownKey 发送间隔按事件类型和来源分别计算，不同任务（或不同播放路径）的事件互不影响
+func cooldownKey(e Event) string {
+	source := e.Data["key"]
+	if e.Type == EventRedirectError {
+		source = e.Data["path"]
+	}
+	if source == nil {
+		return e.Type
+	}
+	return fmt.Sprintf("%s:%v", e.Type, source)
+}
+
+// Send 立即发送事件
+func (n *Notifier) Send(e Event) (err error) {
+	var body []byte
+	switch n.Type {
+	case TypeWebhook:
+		if body, err = json.Marshal(e); err != nil {
+			return
+		}
+		return n.post(body)
+	case TypeHttp:
+		var tpl *template.Template
+		if tpl, err = template.New(n.Name).Funcs(funcs).Parse(n.Template); err != nil {
+			return
+		}
+		var buf bytes.Buffer
+		if err = tpl.Execute(&buf, e); err != nil {
+			return
+		}
+		return n.post(buf.Bytes())
+	case TypeSmtp:
+		return n.mail(e)
+	}
+	return fmt.Errorf("unknown type %q", n.Type)
+}
+
+func (n *Notifier) post(body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, n.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range n.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= http.StatusBadRequest {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// mailTimeout 连接 SMTP 服务器和发送一封邮件的超时时间
+var mailTimeout = 30 * time.Second
+
+func (n *Notifier) mail(e Event) (err error) {
+	cfg := n.Smtp
+	from := cfg.From
+	if from == "" {
+		from = cfg.Username
+	}
+
+	var msg bytes.Buffer
+	msg.WriteString("From: " + from + "\r\n")
+	msg.WriteString("To: " + strings.Join(cfg.To, ", ") + "\r\n")
+	msg.WriteString("Subject: " + mime.BEncoding.Encode("UTF-8", e.Title) + "\r\n")
+	msg.WriteString("Date: " + e.Time.Format(time.RFC1123Z) + "\r\n")
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	msg.WriteString("\r\n")
+	msg.WriteString(strings.ReplaceAll(e.Message, "\n", "\r\n"))
+
+	port := cfg.Port
+	if port == 0 {
+		port = 25
+	}
+	addr := net.JoinHostPort(cfg.Host, strconv.Itoa(port))
+	var auth smtp.Auth
+	if cfg.Username != "" {
+		auth = smtp.PlainAuth("", cfg.Username, cfg.Password, cfg.Host)
+	}
+	// 465 端口需要直接建立 SSL 连接，其他端口在服务器支持时使用 STARTTLS
+	var conn net.Conn
+	if port == 465 {
+		conn, err = tls.DialWithDialer(&net.Dialer{Timeout: mailTimeout}, "tcp", addr, &tls.Config{ServerName: cfg.Host})
+	} else {
+		conn, err = net.DialTimeout("tcp", addr, mailTimeout)
+	}
+	if err != nil {
+		return
+	}
+	// 限制整个发送过程的时间，SMTP 服务器无响应时不会一直阻塞
+	if err = conn.SetDeadline(time.Now().Add(mailTimeout)); err != nil {
+		_ = conn.Close()
+		return
+	}
+	c, err := smtp.NewClient(conn, cfg.Host)
+	if err != nil {
+		_ = conn.Close()
+		return
+	}
+	defer c.Close()
+	if port != 465 {
+		if ok, _ := c.Extension("STARTTLS"); ok {
+			if err = c.StartTLS(&tls.Config{ServerName: cfg.Host}); err != nil {
+				return
+			}
+		}
+	}
+	if auth != nil {
+		if err = c.Auth(auth); err != nil {
+			return
+		}
+	}
+	if err = c.Mail(from); err != nil {
+		return
+	}
+	for _, to := range cfg.To {
+		if err = c.Rcpt(to); err != nil {
+			return
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return
+	}
+	if _, err = w.Write(msg.Bytes()); err != nil {
+		return
+	}
+	if err = w.Close(); err != nil {
+		return
+	}
+	return c.Quit()
+}
@@ -0,0 +1,75 @@
+package notify
+
+import (
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestAcceptCooldown(t *testing.T) {
+	n := &Notifier{Enable: true, Cooldown: 60}
+	now := time.Now()
+	failed := func(key string, after time.Duration) Event {
+		return Event{Type: EventJobFailed, Time: now.Add(after), Data: map[string]any{"key": key}}
+	}
+	redirect := func(path string, after time.Duration) Event {
+		return Event{Type: EventRedirectError, Time: now.Add(after), Data: map[string]any{"path": path, "key": nil}}
+	}
+
+	tests := []struct {
+		name string
+		e    Event
+		want bool
+	}{
+		{"job A fails", failed("a", 0), true},
+		{"job A fails again within cooldown", failed("a", time.Second), false},
+		{"job B fails within cooldown of job A", failed("b", 2*time.Second), true},
+		{"job A succeeds", Event{Type: EventJobSuccess, Time: now.Add(3 * time.Second), Data: map[string]any{"key": "a"}}, true},
+		{"job A fails after cooldown", failed("a", time.Minute), true},
+		{"redirect error", redirect("/movie/a.mkv", 0), true},
+		{"redirect error on the same path", redirect("/movie/a.mkv", time.Second), false},
+		{"redirect error on another path", redirect("/movie/b.mkv", time.Second), true},
+	}
+	for _, tt := range tests {
+		if got := n.accept(tt.e); got != tt.want {
+			t.Errorf("%s: accept() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestMailTimeout(t *testing.T) {
+	timeout := mailTimeout
+	mailTimeout = 100 * time.Millisecond
+	t.Cleanup(func() { mailTimeout = timeout })
+
+	// 接受连接但从不发送问候的 SMTP 服务器
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			t.Cleanup(func() { _ = conn.Close() })
+		}
+	}()
+
+	host, port, _ := net.SplitHostPort(ln.Addr().String())
+	p, _ := strconv.Atoi(port)
+	n := &Notifier{Smtp: Smtp{Host: host, Port: p, From: "astrm@example.com", To: []string{"me@example.com"}}}
+	done := make(chan error, 1)
+	go func() { done <- n.mail(Event{Title: "test", Time: time.Now()}) }()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("mail() to an unresponsive server should return an error")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("mail() hung on an unresponsive server")
+	}
+}
@@ -0,0 +1,188 @@
+package webdav
+
+import (
+	"astrm/service/job"
+	"astrm/utils"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+// Server WebDAV 源端，例如 alist 的 /dav
+type Server struct {
+	Id        int    `yaml:"-" json:"id"`
+	Name      string `yaml:"name" json:"name,omitempty"`
+	Endpoint  string `yaml:"endpoint" json:"endpoint,omitempty"` // WebDAV 地址，如 http://alist:5244/dav
+	Username  string `yaml:"username" json:"username,omitempty"`
+	Password  string `yaml:"password" json:"password,omitempty"`
+	PublicURL string `yaml:"publicUrl" json:"publicUrl,omitempty"` // strm 中使用的地址前缀，不写表示使用 Endpoint
+	StrmAuth  bool   `yaml:"strmAuth" json:"strmAuth"`             // strm 中的地址是否带上用户名和密码
+
+	Client *http.Client `yaml:"-" json:"-"`
+}
+
+// propfind 请求体，只获取需要的属性
+const propfind = `<?xml version="1.0" encoding="utf-8"?>
+<d:propfind xmlns:d="DAV:">
+  <d:prop>
+    <d:resourcetype/>
+    <d:getcontentlength/>
+    <d:getlastmodified/>
+  </d:prop>
+</d:propfind>`
+
+type multistatus struct {
+	Responses []struct {
+		Href     string `xml:"href"`
+		Propstat []struct {
+			Prop struct {
+				ResourceType struct {
+					Collection *struct{} `xml:"collection"`
+				} `xml:"resourcetype"`
+				ContentLength int64  `xml:"getcontentlength"`
+				LastModified  string `xml:"getlastmodified"`
+			} `xml:"prop"`
+			Status string `xml:"status"`
+		} `xml:"propstat"`
+	} `xml:"response"`
+}
+
+func (w *Server) Handle(s *job.Session) error {
+	return job.Walk(s, w)
+}
+
+func (w *Server) client() *http.Client {
+	if w.Client != nil {
+		return w.Client
+	}
+	return &http.Client{Timeout: 30 * time.Second}
+}
+
+// url 返回 WebDAV 上路径对应的地址
+func (w *Server) url(base, p string) (*url.URL, error) {
+	u, err := url.Parse(strings.TrimSpace(base))
+	if err != nil {
+		return nil, err
+	}
+	u.Path = path.Join("/", u.Path, p)
+	if strings.HasSuffix(p, "/") && !strings.HasSuffix(u.Path, "/") {
+		// 目录保留末尾的 /，否则部分服务器会重定向，客户端重定向后改用 GET
+		u.Path += "/"
+	}
+	u.RawPath = ""
+	return u, nil
+}
+
+func (w *Server) do(ctx context.Context, client *http.Client, method, p string, body io.Reader, headers map[string]string) (res *http.Response, err error) {
+	var u *url.URL
+	if u, err = w.url(w.Endpoint, p); err != nil {
+		return
+	}
+	var req *http.Request
+	if req, err = http.NewRequestWithContext(ctx, method, u.String(), body); err != nil {
+		return
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	if w.Username != "" {
+		req.SetBasicAuth(w.Username, w.Password)
+	}
+	return client.Do(req)
+}
+
+// List 使用 PROPFIND 列出目录下的文件和子目录
+func (w *Server) List(ctx context.Context, dir string) (entries []*job.Entry, err error) {
+	var res *http.Response
+	res, err = w.do(ctx, w.client(), "PROPFIND", dir+"/", strings.NewReader(propfind), map[string]string{
+		"Depth":        "1",
+		"Content-Type": "application/xml; charset=utf-8",
+	})
+	if err != nil {
+		return
+	}
+	defer func(Body io.ReadCloser) {
+		_ = Body.Close()
+	}(res.Body)
+	if res.StatusCode != http.StatusMultiStatus {
+		return nil, fmt.Errorf("PROPFIND %s: status code %d", dir, res.StatusCode)
+	}
+
+	var ms multistatus
+	if err = xml.NewDecoder(res.Body).Decode(&ms); err != nil {
+		return nil, fmt.Errorf("PROPFIND %s: %w", dir, err)
+	}
+
+	base, _ := w.url(w.Endpoint, "/")
+	dir = path.Join("/", dir)
+	for _, r := range ms.Responses {
+		var p string
+		if p, err = w.relPath(base.Path, r.Href); err != nil {
+			return nil, err
+		}
+		if p == dir {
+			continue
+		}
+		e := &job.Entry{Path: p}
+		for _, ps := range r.Propstat {
+			if !strings.Contains(ps.Status, " 200") {
+				continue
+			}
+			e.IsDir = ps.Prop.ResourceType.Collection != nil
+			e.Size = ps.Prop.ContentLength
+			if t, parseErr := http.ParseTime(ps.Prop.LastModified); parseErr == nil {
+				e.Modified = t
+			}
+		}
+		entries = append(entries, e)
+	}
+	return
+}
+
+// relPath 将 href 转换为相对 Endpoint 的路径
+func (w *Server) relPath(basePath, href string) (string, error) {
+	u, err := url.Parse(href)
+	if err != nil {
+		return "", fmt.Errorf("invalid href %q: %w", href, err)
+	}
+	p := path.Join("/", u.Path)
+	if basePath = strings.TrimSuffix(basePath, "/"); p == basePath {
+		return "/", nil
+	}
+	return path.Join("/", strings.TrimPrefix(p, basePath+"/")), nil
+}
+
+// Strm 返回文件的 WebDAV 地址
+func (w *Server) Strm(_ context.Context, e *job.Entry) (string, error) {
+	base := w.PublicURL
+	if base == "" {
+		base = w.Endpoint
+	}
+	u, err := w.url(base, e.Path)
+	if err != nil {
+		return "", err
+	}
+	if w.StrmAuth && w.Username != "" {
+		u.User = url.UserPassword(w.Username, w.Password)
+	}
+	return u.String(), nil
+}
+
+// Open 下载 extra 文件
+func (w *Server) Open(ctx context.Context, e *job.Entry) (io.ReadCloser, error) {
+	res, err := w.do(ctx, utils.DownloadClient(w.Client), http.MethodGet, e.Path, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		_ = res.Body.Close()
+		return nil, fmt.Errorf("GET %s: status code %d", e.Path, res.StatusCode)
+	}
+	return res.Body, nil
+}
@@ -22,6 +22,23 @@ const (
 
 )
 
+// DownloadTransport 下载文件用的 Transport，只限制建立连接和等待响应头的时间，
+// 读取响应体不设超时（extra 下载限速时大文件会超过固定的超时时间），由请求的 ctx 取消
+var DownloadTransport http.RoundTripper = func() *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.ResponseHeaderTimeout = 30 * time.Second
+	return t
+}()
+
+// DownloadClient 返回下载 extra 文件用的客户端，不限制读取响应体的时间；
+// c 不为 nil 时直接使用 c，用于源端配置了自定义客户端的情况
+func DownloadClient(c *http.Client) *http.Client {
+	if c != nil {
+		return c
+	}
+	return &http.Client{Transport: DownloadTransport}
+}
+
 var (
 	ErrInvalidLocationHeader = errors.New("重定向 Location 头无效")
 	ErrMaxRedirectsExceeded  = fmt.Errorf("超过最大重定向次数限制（%d）", MaxRedirectAttempts)
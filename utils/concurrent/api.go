@@ -2,116 +2,109 @@ package concurrent
 
 import (
 	"context"
-	"errors"
-	"reflect"
+	"fmt"
 	"sync"
 )
 
-// Task represents a task that can be submitted to the thread pool.
-type Task struct {
-	f    any
-	args []any
-	done chan []any
-}
+// Errors aggregates the errors returned by tasks, in completion order.
+type Errors []error
 
-// Future is an interface for getting the result of a task.
-type Future interface {
-	Get() []any
+func (e Errors) Error() string {
+	if len(e) == 1 {
+		return e[0].Error()
+	}
+	return fmt.Sprintf("%d tasks failed, first error: %v", len(e), e[0])
 }
 
-// futureImpl implements the Future interface.
-type futureImpl struct {
-	resultChan <-chan []any
+// Unwrap allows errors.Is and errors.As to inspect every task error.
+func (e Errors) Unwrap() []error {
+	return e
 }
 
-func (f *futureImpl) Get() []any {
-	result := <-f.resultChan
-	return result
-}
+// Pool runs tasks of type T on a fixed number of workers.
+type Pool[T any] struct {
+	fn     func(ctx context.Context, task T) error
+	tasks  chan T
+	wg     sync.WaitGroup
+	ctx    context.Context
+	cancel context.CancelFunc
 
-// Pool represents a pool of workers and a job queue.
-type Pool struct {
-	tasks     chan *Task
-	semaphore chan any
-	wg        sync.WaitGroup
-	ctx       context.Context
-	cancelCtx context.CancelFunc
+	mu   sync.Mutex
+	errs Errors
 }
 
-// NewPool creates a new Pool with the specified number of workers.
-func NewPool(numWorkers int) *Pool {
-	ctx, cancel := context.WithCancel(context.Background())
-	tp := &Pool{
-		tasks:     make(chan *Task, numWorkers),
-		semaphore: make(chan any, numWorkers),
-		ctx:       ctx,
-		cancelCtx: cancel,
+// NewPool creates a Pool with numWorkers workers that call fn for every submitted task.
+//
+// At most queueSize tasks wait in the queue, Submit blocks once it is full.
+// When ctx is done, Submit stops accepting tasks and queued tasks are dropped.
+func NewPool[T any](ctx context.Context, numWorkers, queueSize int, fn func(ctx context.Context, task T) error) *Pool[T] {
+	if numWorkers < 1 {
+		numWorkers = 1
+	}
+	if queueSize < 0 {
+		queueSize = 0
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	p := &Pool[T]{
+		fn:     fn,
+		tasks:  make(chan T, queueSize),
+		ctx:    ctx,
+		cancel: cancel,
 	}
-
 	for i := 0; i < numWorkers; i++ {
-		tp.wg.Add(1)
-		go tp.worker(i)
+		p.wg.Add(1)
+		go p.worker()
 	}
-
-	return tp
+	return p
 }
 
-// worker processes tasks from the task channel.
-func (tp *Pool) worker(_ int) {
-	defer tp.wg.Done()
+func (p *Pool[T]) worker() {
+	defer p.wg.Done()
 	for {
 		select {
-		case task, ok := <-tp.tasks:
+		case task, ok := <-p.tasks:
 			if !ok {
-				return // Channel closed, exit
+				return
+			}
+			if p.ctx.Err() != nil {
+				continue // cancelled, drop the queued task
 			}
-			result := callFunction(task.f, task.args...)
-			task.done <- result
-			<-tp.semaphore
-		case <-tp.ctx.Done():
-			return // Context cancelled, exit
+			if err := p.fn(p.ctx, task); err != nil {
+				p.mu.Lock()
+				p.errs = append(p.errs, err)
+				p.mu.Unlock()
+			}
+		case <-p.ctx.Done():
+			return
 		}
 	}
 }
 
-// Submit submits a task to the thread pool and returns a Future.
-func (tp *Pool) Submit(fn any, args ...any) (fu Future) {
-	fnVal := reflect.ValueOf(fn)
-	if fnVal.Kind() != reflect.Func {
-		panic(errors.New("provided value is not a function"))
-	}
-	done := make(chan []any, 1)
-	task := &Task{f: fn, done: done, args: args}
-	tp.semaphore <- new(any)
-	tp.tasks <- task
-	fu = &futureImpl{resultChan: done}
-	return
-}
-
-// callFunction uses reflection to call the function with the provided arguments.
-func callFunction(fn interface{}, args ...interface{}) []any {
-	fnVal := reflect.ValueOf(fn)
-	in := make([]reflect.Value, len(args))
-	for i, arg := range args {
-		in[i] = reflect.ValueOf(arg)
+// Submit queues a task, blocking while the queue is full.
+// It returns the context error if the pool is cancelled before the task is queued.
+// Submit must not be called after Wait.
+func (p *Pool[T]) Submit(task T) error {
+	if err := p.ctx.Err(); err != nil {
+		return err
 	}
-
-	results := fnVal.Call(in)
-	// 将结果转换为接口切片
-	out := make([]interface{}, len(results))
-	for i, result := range results {
-		out[i] = result.Interface()
+	select {
+	case p.tasks <- task:
+		return nil
+	case <-p.ctx.Done():
+		return p.ctx.Err()
 	}
-	return out
-}
-
-// Shutdown gracefully shuts down the thread pool.
-func (tp *Pool) Shutdown() {
-	close(tp.tasks)
-	tp.cancelCtx()
-	tp.wg.Wait()
 }
 
-func (tp *Pool) Wait() {
-	tp.wg.Wait()
+// Wait stops accepting tasks, waits for the queued tasks to finish and
+// returns the task errors as Errors, or nil if every task succeeded.
+func (p *Pool[T]) Wait() error {
+	close(p.tasks)
+	p.wg.Wait()
+	p.cancel()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.errs) == 0 {
+		return nil
+	}
+	return p.errs
 }
@@ -0,0 +1,218 @@
+package concurrent
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPoolErrors(t *testing.T) {
+	errOdd := errors.New("odd")
+	p := NewPool(context.Background(), 4, 8, func(_ context.Context, n int) error {
+		if n%2 == 1 {
+			return errOdd
+		}
+		return nil
+	})
+	for i := 0; i < 10; i++ {
+		if err := p.Submit(i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := p.Wait()
+
+	var errs Errors
+	if !errors.As(err, &errs) {
+		t.Fatalf("Wait() = %v, want Errors", err)
+	}
+	if len(errs) != 5 {
+		t.Errorf("len(Errors) = %d, want 5", len(errs))
+	}
+	if !errors.Is(err, errOdd) {
+		t.Error("errors.Is(Wait(), errOdd) = false")
+	}
+	if want := "5 tasks failed, first error: odd"; err.Error() != want {
+		t.Errorf("Error() = %q, want %q", err.Error(), want)
+	}
+}
+
+func TestPoolNoError(t *testing.T) {
+	var done atomic.Int32
+	p := NewPool(context.Background(), 2, 0, func(_ context.Context, _ int) error {
+		done.Add(1)
+		return nil
+	})
+	for i := 0; i < 100; i++ {
+		if err := p.Submit(i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := p.Wait(); err != nil {
+		t.Fatalf("Wait() = %v, want nil", err)
+	}
+	if done.Load() != 100 {
+		t.Errorf("ran %d tasks, want 100", done.Load())
+	}
+}
+
+func TestPoolSubmitCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	block := make(chan struct{})
+	p := NewPool(ctx, 1, 0, func(ctx context.Context, _ int) error {
+		select {
+		case <-block:
+		case <-ctx.Done():
+		}
+		return nil
+	})
+	// the only worker is busy and there is no queue, so the second Submit blocks
+	if err := p.Submit(0); err != nil {
+		t.Fatal(err)
+	}
+	submitted := make(chan error, 1)
+	go func() {
+		submitted <- p.Submit(1)
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+	select {
+	case err := <-submitted:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Submit() = %v, want context.Canceled", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Submit() still blocked after cancel")
+	}
+	if err := p.Submit(2); !errors.Is(err, context.Canceled) {
+		t.Errorf("Submit() after cancel = %v, want context.Canceled", err)
+	}
+	close(block)
+	if err := p.Wait(); err != nil {
+		t.Errorf("Wait() = %v, want nil", err)
+	}
+}
+
+func TestPoolWaitDropsQueued(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	started := make(chan struct{})
+	var ran atomic.Int32
+	p := NewPool(ctx, 1, 10, func(ctx context.Context, n int) error {
+		ran.Add(1)
+		if n == 0 {
+			close(started)
+			<-ctx.Done()
+			return ctx.Err()
+		}
+		return nil
+	})
+	for i := 0; i < 10; i++ {
+		if err := p.Submit(i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	<-started
+	cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- p.Wait()
+	}()
+	select {
+	case err := <-done:
+		// only the running task reports an error, queued tasks are dropped
+		var errs Errors
+		if !errors.As(err, &errs) || len(errs) != 1 || !errors.Is(err, context.Canceled) {
+			t.Errorf("Wait() = %v, want one context.Canceled", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Wait() blocked on dropped tasks")
+	}
+	if ran.Load() != 1 {
+		t.Errorf("ran %d tasks, want 1", ran.Load())
+	}
+}
+
+// reflectPool is the reflection based pool that Pool replaced, kept as a benchmark baseline.
+type reflectPool struct {
+	tasks chan reflectTask
+	wg    sync.WaitGroup
+}
+
+type reflectTask struct {
+	fn   any
+	args []any
+	done chan []any
+}
+
+func newReflectPool(numWorkers int) *reflectPool {
+	p := &reflectPool{tasks: make(chan reflectTask, numWorkers)}
+	for i := 0; i < numWorkers; i++ {
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			for task := range p.tasks {
+				in := make([]reflect.Value, len(task.args))
+				for i, arg := range task.args {
+					in[i] = reflect.ValueOf(arg)
+				}
+				results := reflect.ValueOf(task.fn).Call(in)
+				out := make([]any, len(results))
+				for i, r := range results {
+					out[i] = r.Interface()
+				}
+				task.done <- out
+			}
+		}()
+	}
+	return p
+}
+
+func (p *reflectPool) submit(fn any, args ...any) <-chan []any {
+	done := make(chan []any, 1)
+	p.tasks <- reflectTask{fn: fn, args: args, done: done}
+	return done
+}
+
+func (p *reflectPool) shutdown() {
+	close(p.tasks)
+	p.wg.Wait()
+}
+
+func work(_ context.Context, n int) error {
+	if n < 0 {
+		return errors.New("negative")
+	}
+	return nil
+}
+
+func BenchmarkPool(b *testing.B) {
+	b.ReportAllocs()
+	p := NewPool(context.Background(), 8, 8, work)
+	for i := 0; i < b.N; i++ {
+		_ = p.Submit(i)
+	}
+	if err := p.Wait(); err != nil {
+		b.Fatal(err)
+	}
+}
+
+func BenchmarkReflectPool(b *testing.B) {
+	b.ReportAllocs()
+	p := newReflectPool(8)
+	ctx := context.Background()
+	futures := make([]<-chan []any, 0, b.N)
+	for i := 0; i < b.N; i++ {
+		futures = append(futures, p.submit(work, ctx, i))
+	}
+	for _, f := range futures {
+		if res := <-f; res[0] != nil {
+			b.Fatal(res[0])
+		}
+	}
+	p.shutdown()
+}
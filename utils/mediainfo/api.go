@@ -0,0 +1,153 @@
+package mediainfo
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Info 从文件名中解析出的媒体信息
+type Info struct {
+	Title      string `json:"title"`
+	Year       int    `json:"year,omitempty"`
+	Season     int    `json:"season,omitempty"`     // 0 表示未知
+	Episode    int    `json:"episode,omitempty"`    // 0 表示不是剧集
+	Resolution string `json:"resolution,omitempty"` // 如 2160p、1080p
+}
+
+// IsEpisode 是否解析出了集数
+func (i Info) IsEpisode() bool {
+	return i.Episode > 0
+}
+
+// 标记，标题取第一个标记之前的部分
+var (
+	groupRegex      = regexp.MustCompile(`^\s*(?:\[[^\]]*\]|【[^】]*】)\s*`)
+	resolutionRegex = regexp.MustCompile(`(?i)(?:^|[^a-z0-9])(2160p|1080p|1080i|720p|576p|480p|4k|8k)(?:[^a-z0-9]|$)`)
+	seasonEpRegex   = regexp.MustCompile(`(?i)(?:^|[^a-z0-9])s(\d{1,2})[ ._-]?e(\d{1,4})(?:[^0-9]|$)`)
+	crossEpRegex    = regexp.MustCompile(`(?i)(?:^|[^a-z0-9])(\d{1,2})x(\d{2,3})(?:[^0-9]|$)`)
+	cnSeasonRegex   = regexp.MustCompile(`第\s*([0-9一二三四五六七八九十]+)\s*季`)
+	cnEpisodeRegex  = regexp.MustCompile(`第\s*([0-9一二三四五六七八九十百]+)\s*[集话話]`)
+	epRegex         = regexp.MustCompile(`(?i)(?:^|[^a-z0-9])ep?\.?\s?(\d{1,4})(?:v\d)?(?:[^a-z0-9]|$)`)
+	seasonRegex     = regexp.MustCompile(`(?i)(?:^|[^a-z0-9])(?:s|season\s?)(\d{1,2})(?:[^a-z0-9]|$)`)
+	dashEpRegex     = regexp.MustCompile(`\s-\s(\d{1,4})(?:v\d)?(?:\s|\[|\(|$)`)
+	numberRegex     = regexp.MustCompile(`^\s*(\d{1,4})\s*$`)
+	yearRegex       = regexp.MustCompile(`(?:19|20)\d{2}`)
+	tagRegex        = regexp.MustCompile(`(?i)(?:^|[^a-z0-9])(web-?dl|webrip|bluray|blu-ray|bdrip|hdtv|hdrip|dvdrip|remux|x264|x265|h\.?264|h\.?265|hevc|avc|10bit|hdr|dv|aac|ac3|dts|atmos|uncut|complete|proper|repack)(?:[^a-z0-9]|$)`)
+	spaceRegex      = regexp.MustCompile(`\s+`)
+)
+
+// Parse 解析不含目录和扩展名的文件名，例如 "Show.Name.2020.S01E02.1080p.WEB-DL"
+func Parse(name string) (info Info) {
+	name = groupRegex.ReplaceAllString(name, "")
+	end := len(name)
+	mark := func(loc []int) {
+		if loc != nil && loc[0] < end {
+			end = loc[0]
+		}
+	}
+
+	if m := resolutionRegex.FindStringSubmatchIndex(name); m != nil {
+		info.Resolution = strings.ToLower(name[m[2]:m[3]])
+		switch info.Resolution {
+		case "4k":
+			info.Resolution = "2160p"
+		case "8k":
+			info.Resolution = "4320p"
+		}
+		mark(m)
+	}
+
+	switch {
+	case matchPair(seasonEpRegex, name, &info.Season, &info.Episode, mark):
+	case matchPair(crossEpRegex, name, &info.Season, &info.Episode, mark):
+	default:
+		if m := cnEpisodeRegex.FindStringSubmatchIndex(name); m != nil {
+			info.Episode = number(name[m[2]:m[3]])
+			mark(m)
+		} else if m = epRegex.FindStringSubmatchIndex(name); m != nil {
+			info.Episode, _ = strconv.Atoi(name[m[2]:m[3]])
+			mark(m)
+		} else if m = dashEpRegex.FindStringSubmatchIndex(name); m != nil {
+			info.Episode, _ = strconv.Atoi(name[m[2]:m[3]])
+			mark(m)
+		} else if m = numberRegex.FindStringSubmatchIndex(name); m != nil {
+			// 整个文件名只有数字，例如 01.mkv
+			info.Episode, _ = strconv.Atoi(name[m[2]:m[3]])
+			mark(m)
+		}
+		if m := cnSeasonRegex.FindStringSubmatchIndex(name); m != nil {
+			info.Season = number(name[m[2]:m[3]])
+			mark(m)
+		} else if m = seasonRegex.FindStringSubmatchIndex(name); m != nil {
+			info.Season, _ = strconv.Atoi(name[m[2]:m[3]])
+			mark(m)
+		}
+	}
+
+	// 年份取最后一个，开头的不算，避免把以年份命名的标题当作年份，例如 "2012.2009.1080p"
+	var year []int
+	for _, m := range yearRegex.FindAllStringIndex(name, -1) {
+		if m[0] > 0 && !isDigit(name[m[0]-1]) && (m[1] == len(name) || !isDigit(name[m[1]]) && !strings.ContainsRune("pPiI", rune(name[m[1]]))) {
+			year = m
+		}
+	}
+	if year != nil {
+		info.Year, _ = strconv.Atoi(name[year[0]:year[1]])
+		mark(year)
+	}
+	mark(tagRegex.FindStringIndex(name))
+
+	info.Title = cleanTitle(name[:end])
+	return
+}
+
+func matchPair(re *regexp.Regexp, name string, season, episode *int, mark func([]int)) bool {
+	m := re.FindStringSubmatchIndex(name)
+	if m == nil {
+		return false
+	}
+	*season, _ = strconv.Atoi(name[m[2]:m[3]])
+	*episode, _ = strconv.Atoi(name[m[4]:m[5]])
+	mark(m)
+	return true
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func cleanTitle(title string) string {
+	title = strings.NewReplacer(".", " ", "_", " ").Replace(title)
+	title = spaceRegex.ReplaceAllString(title, " ")
+	return strings.Trim(title, " -[](){}【】")
+}
+
+var cnDigits = map[rune]int{'一': 1, '二': 2, '三': 3, '四': 4, '五': 5, '六': 6, '七': 7, '八': 8, '九': 9}
+
+// number 解析阿拉伯数字或一百以内的中文数字
+func number(s string) int {
+	if n, err := strconv.Atoi(s); err == nil {
+		return n
+	}
+	var n, cur int
+	for _, r := range s {
+		switch {
+		case r == '十':
+			if cur == 0 {
+				cur = 1
+			}
+			n += cur * 10
+			cur = 0
+		case r == '百':
+			if cur == 0 {
+				cur = 1
+			}
+			n += cur * 100
+			cur = 0
+		default:
+			cur = cnDigits[r]
+		}
+	}
+	return n + cur
+}
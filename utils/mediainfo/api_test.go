@@ -0,0 +1,47 @@
+package mediainfo
+
+import "testing"
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name string
+		want Info
+	}{
+		// SxxEyy
+		{"Show.Name.2020.S01E02.1080p.WEB-DL.x264", Info{Title: "Show Name", Year: 2020, Season: 1, Episode: 2, Resolution: "1080p"}},
+		{"Show Name S02 E10", Info{Title: "Show Name", Season: 2, Episode: 10}},
+		// 1x05
+		{"Friends.3x07.The.Racebrate", Info{Title: "Friends", Season: 3, Episode: 7}},
+		{"Show 1x05", Info{Title: "Show", Season: 1, Episode: 5}},
+		// 第x季 / 第x集
+		{"庆余年 第二季 第05集 4K", Info{Title: "庆余年", Season: 2, Episode: 5, Resolution: "2160p"}},
+		{"庆余年.2019.第十二集", Info{Title: "庆余年", Year: 2019, Episode: 12}},
+		{"某剧 第1季 第二十三话", Info{Title: "某剧", Season: 1, Episode: 23}},
+		// EP01
+		{"EP01", Info{Episode: 1}},
+		{"Show Name EP12 720p", Info{Title: "Show Name", Episode: 12, Resolution: "720p"}},
+		{"Show Name E12", Info{Title: "Show Name", Episode: 12}},
+		// [Group] Show - 01
+		{"[Group] Show Name - 01 [1080p]", Info{Title: "Show Name", Episode: 1, Resolution: "1080p"}},
+		{"【字幕组】Show Name - 12v2", Info{Title: "Show Name", Episode: 12}},
+		// 只有集数
+		{"01", Info{Episode: 1}},
+		// 季目录
+		{"Show Name Season 2", Info{Title: "Show Name", Season: 2}},
+		{"Show.Name.S02", Info{Title: "Show Name", Season: 2}},
+		// 年份
+		{"2012.2009.1080p.BluRay", Info{Title: "2012", Year: 2009, Resolution: "1080p"}},
+		{"Blade Runner 2049 (2017) 1080p", Info{Title: "Blade Runner 2049", Year: 2017, Resolution: "1080p"}},
+		{"1917 (2019)", Info{Title: "1917", Year: 2019}},
+		{"1917 1080p", Info{Title: "1917", Resolution: "1080p"}},
+		{"The.Matrix.1999.2160p.UHD.BluRay", Info{Title: "The Matrix", Year: 1999, Resolution: "2160p"}},
+		{"流浪地球", Info{Title: "流浪地球"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Parse(tt.name); got != tt.want {
+				t.Errorf("Parse(%q) = %+v, want %+v", tt.name, got, tt.want)
+			}
+		})
+	}
+}